@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"dagger/codecov/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+var githubPullRequestRefRegexp = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// githubEvent is the part of GitHub event payload used to get the pull request head
+type githubEvent struct {
+	PullRequest *struct {
+		Number int `json:"number"`
+		Head   struct {
+			Sha string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// CiEnvironment is the CI context detected from the host environment
+type CiEnvironment struct {
+	// The CI provider: github-actions, gitlab-ci, jenkins or drone. Empty when not detected
+	Provider string
+
+	// The commit SHA
+	Sha string
+
+	// The branch name
+	Branch string
+
+	// The pull request number, 0 when not a pull request
+	Pr int
+
+	// The repository slug, like owner/repo
+	Slug string
+
+	// The git service, like github or gitlab
+	GitService string
+
+	// The build code
+	Build string
+
+	// The build URL
+	BuildUrl string
+
+	// The job code
+	Job string
+}
+
+// DetectCi detect the CI provider and its context from the host environment
+func (h *Codecov) DetectCi(
+	ctx context.Context,
+
+	// The host environment, as the output of env command
+	env *dagger.Secret,
+
+	// The GitHub event payload, the file at GITHUB_EVENT_PATH. It's required on GitHub pull request events,
+	// because GITHUB_SHA is the temporary merge commit and not the pull request head
+	// +optional
+	githubEvent *dagger.File,
+) (*CiEnvironment, error) {
+	content, err := env.Plaintext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when read CI environment")
+	}
+
+	event := ""
+	if githubEvent != nil {
+		if event, err = githubEvent.Contents(ctx); err != nil {
+			return nil, errors.Wrap(err, "Error when read GitHub event")
+		}
+	}
+
+	return detectCi(parseEnv(content), event)
+}
+
+// WithCiEnvironment detect the CI context from the host environment, and forward the commit SHA, branch,
+// pull request and build URL to codecovcli when they are not set on function call
+func (h *Codecov) WithCiEnvironment(
+	ctx context.Context,
+
+	// The host environment, as the output of env command
+	env *dagger.Secret,
+
+	// The GitHub event payload, the file at GITHUB_EVENT_PATH. It's required on GitHub pull request events,
+	// because GITHUB_SHA is the temporary merge commit and not the pull request head
+	// +optional
+	githubEvent *dagger.File,
+) (*Codecov, error) {
+	ci, err := h.DetectCi(ctx, env, githubEvent)
+	if err != nil {
+		return nil, err
+	}
+	h.Ci = ci
+
+	return h, nil
+}
+
+// WithDryRun validate the reports and return the upload payload without sending anything
+func (h *Codecov) WithDryRun() *Codecov {
+	h.DryRun = true
+	return h
+}
+
+// parseEnv parse the output of env command
+func parseEnv(content string) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+
+	return env
+}
+
+// detectCi return the CI context of the first provider found on the environment.
+// The GitHub event payload is only used on GitHub pull request events, it can be empty otherwise
+func detectCi(env map[string]string, githubEventContent string) (*CiEnvironment, error) {
+	ci := &CiEnvironment{}
+
+	switch {
+	case env["GITHUB_ACTIONS"] == "true":
+		ci.Provider = "github-actions"
+		ci.GitService = "github"
+		ci.Sha = env["GITHUB_SHA"]
+		ci.Branch = firstNotEmpty(env["GITHUB_HEAD_REF"], env["GITHUB_REF_NAME"], strings.TrimPrefix(env["GITHUB_REF"], "refs/heads/"))
+		if match := githubPullRequestRefRegexp.FindStringSubmatch(env["GITHUB_REF"]); match != nil {
+			ci.Pr, _ = strconv.Atoi(match[1])
+		}
+		ci.Slug = env["GITHUB_REPOSITORY"]
+		ci.Build = env["GITHUB_RUN_ID"]
+		ci.Job = env["GITHUB_JOB"]
+		if env["GITHUB_SERVER_URL"] != "" && env["GITHUB_RUN_ID"] != "" {
+			ci.BuildUrl = fmt.Sprintf("%s/%s/actions/runs/%s", env["GITHUB_SERVER_URL"], env["GITHUB_REPOSITORY"], env["GITHUB_RUN_ID"])
+		}
+		// On pull request, GITHUB_SHA is the merge commit of the head on the base branch
+		if strings.HasPrefix(env["GITHUB_EVENT_NAME"], "pull_request") {
+			if githubEventContent == "" {
+				return nil, errors.Errorf("The GitHub event file is required on %s event to get the pull request head SHA", env["GITHUB_EVENT_NAME"])
+			}
+			event := &githubEvent{}
+			if err := json.Unmarshal([]byte(githubEventContent), event); err != nil {
+				return nil, errors.Wrap(err, "Error when decode GitHub event")
+			}
+			if event.PullRequest == nil || event.PullRequest.Head.Sha == "" {
+				return nil, errors.New("The GitHub event not contain the pull request head SHA")
+			}
+			ci.Sha = event.PullRequest.Head.Sha
+			if ci.Pr == 0 {
+				ci.Pr = event.PullRequest.Number
+			}
+		}
+	case env["GITLAB_CI"] == "true":
+		ci.Provider = "gitlab-ci"
+		ci.GitService = "gitlab"
+		ci.Sha = firstNotEmpty(env["CI_MERGE_REQUEST_SOURCE_BRANCH_SHA"], env["CI_COMMIT_SHA"])
+		ci.Branch = firstNotEmpty(env["CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"], env["CI_COMMIT_BRANCH"], env["CI_COMMIT_REF_NAME"])
+		ci.Pr, _ = strconv.Atoi(env["CI_MERGE_REQUEST_IID"])
+		ci.Slug = env["CI_PROJECT_PATH"]
+		ci.Build = env["CI_PIPELINE_ID"]
+		ci.Job = env["CI_JOB_ID"]
+		ci.BuildUrl = env["CI_JOB_URL"]
+	case env["JENKINS_URL"] != "":
+		ci.Provider = "jenkins"
+		ci.Sha = firstNotEmpty(env["ghprbActualCommit"], env["GIT_COMMIT"])
+		ci.Branch = firstNotEmpty(env["ghprbSourceBranch"], env["CHANGE_BRANCH"], env["BRANCH_NAME"], strings.TrimPrefix(env["GIT_BRANCH"], "origin/"))
+		ci.Pr, _ = strconv.Atoi(firstNotEmpty(env["ghprbPullId"], env["CHANGE_ID"]))
+		ci.Build = env["BUILD_NUMBER"]
+		ci.Job = env["JOB_NAME"]
+		ci.BuildUrl = env["BUILD_URL"]
+	case env["DRONE"] == "true":
+		ci.Provider = "drone"
+		ci.Sha = env["DRONE_COMMIT_SHA"]
+		ci.Branch = firstNotEmpty(env["DRONE_SOURCE_BRANCH"], env["DRONE_BRANCH"], env["DRONE_COMMIT_BRANCH"])
+		ci.Pr, _ = strconv.Atoi(env["DRONE_PULL_REQUEST"])
+		ci.Slug = env["DRONE_REPO"]
+		ci.Build = env["DRONE_BUILD_NUMBER"]
+		ci.Job = env["DRONE_STEP_NUMBER"]
+		ci.BuildUrl = env["DRONE_BUILD_LINK"]
+	}
+
+	return ci, nil
+}
+
+// firstNotEmpty return the first not empty value
+func firstNotEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
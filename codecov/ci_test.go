@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectCiGithubPullRequest(t *testing.T) {
+	env := map[string]string{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_EVENT_NAME": "pull_request",
+		"GITHUB_SHA":        "1111111111111111111111111111111111111111",
+		"GITHUB_REF":        "refs/pull/12/merge",
+		"GITHUB_HEAD_REF":   "feature",
+		"GITHUB_REPOSITORY": "owner/repo",
+	}
+	event := `{"number":12,"pull_request":{"number":12,"head":{"sha":"2222222222222222222222222222222222222222"}}}`
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		event   string
+		wantSha string
+		wantErr bool
+	}{
+		{
+			name:    "pull request use head SHA",
+			env:     env,
+			event:   event,
+			wantSha: "2222222222222222222222222222222222222222",
+		},
+		{
+			name:    "pull request without event",
+			env:     env,
+			wantErr: true,
+		},
+		{
+			name:    "pull request with invalid event",
+			env:     env,
+			event:   `{"pull_request":{}}`,
+			wantErr: true,
+		},
+		{
+			name: "push use GITHUB_SHA",
+			env: map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_EVENT_NAME": "push",
+				"GITHUB_SHA":        "1111111111111111111111111111111111111111",
+				"GITHUB_REF":        "refs/heads/main",
+			},
+			wantSha: "1111111111111111111111111111111111111111",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ci, err := detectCi(test.env, test.event)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("detectCi() need to return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectCi() error: %s", err.Error())
+			}
+			if ci.Sha != test.wantSha {
+				t.Errorf("detectCi() sha = %q, want %q", ci.Sha, test.wantSha)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"dagger/codecov/internal/dagger"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// The default file name of each coverage format
+var coverageFileNames = map[string]string{
+	"go":        "coverage.out",
+	"lcov":      "lcov.info",
+	"cobertura": "cobertura.xml",
+	"jacoco":    "jacoco.xml",
+}
+
+// coverageReport is the line coverage of a set of files
+type coverageReport struct {
+	files map[string]*coverageFile
+
+	// The go coverprofile mode, empty when there are no go input
+	mode string
+}
+
+// coverageFile is the coverage of one source file
+type coverageFile struct {
+	path string
+
+	// The hits of each line
+	lines map[int]int
+
+	// The go coverprofile blocks, to keep them when converting go to go
+	blocks map[string]*goBlock
+
+	// True when lines come from a report without go blocks
+	lineCoverage bool
+}
+
+// goBlock is a go coverprofile block
+type goBlock struct {
+	position string
+	numStmt  int
+	count    int
+}
+
+// Convert convert coverage reports between go coverprofile, lcov, cobertura and jacoco.
+// When multiple reports are provided, they are merged on one report.
+func (h *Codecov) Convert(
+	ctx context.Context,
+
+	// The coverage reports
+	reports []*dagger.File,
+
+	// The output format: go, lcov, cobertura or jacoco
+	to string,
+
+	// The input format: auto, go, lcov, cobertura or jacoco. Auto detect the format of each report
+	// +optional
+	// +default="auto"
+	from string,
+
+	// The path prefixes to rewrite, as old=new. Use /src/= to remove the /src/ prefix.
+	// The first matching prefix is used
+	// +optional
+	pathPrefixes []string,
+
+	// The output file name. Default to the usual file name of the format
+	// +optional
+	name string,
+) (*dagger.File, error) {
+	if from == "" {
+		from = "auto"
+	}
+	if !slices.Contains(coverageFormats, to) {
+		return nil, errors.Errorf("Format %s not supported, it need to be one of %s", to, strings.Join(coverageFormats, ", "))
+	}
+	if from != "auto" && !slices.Contains(coverageFormats, from) {
+		return nil, errors.Errorf("Format %s not supported, it need to be auto or one of %s", from, strings.Join(coverageFormats, ", "))
+	}
+	if len(reports) == 0 {
+		return nil, errors.New("You need to provide at least one report")
+	}
+	if name == "" {
+		name = coverageFileNames[to]
+	}
+
+	rewrites := make([][2]string, 0, len(pathPrefixes))
+	for _, prefix := range pathPrefixes {
+		old, new, ok := strings.Cut(prefix, "=")
+		if !ok {
+			return nil, errors.Errorf("Path prefix %s is invalid, it need to be old=new", prefix)
+		}
+		rewrites = append(rewrites, [2]string{old, new})
+	}
+
+	merged := newCoverageReport()
+	for _, report := range reports {
+		content, err := report.Contents(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when read coverage report")
+		}
+
+		format := from
+		if format == "auto" {
+			if format = detectCoverageFormat(content); format == "" {
+				return nil, errors.New("Error when detect the format of coverage report")
+			}
+		}
+
+		parsed, err := parseCoverage(content, format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when parse %s coverage report", format)
+		}
+
+		merged.merge(parsed, rewrites)
+	}
+
+	content, err := renderCoverage(merged, to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when render %s coverage report", to)
+	}
+
+	return dag.Directory().
+		WithNewFile(name, content).
+		File(name), nil
+}
+
+func newCoverageReport() *coverageReport {
+	return &coverageReport{
+		files: make(map[string]*coverageFile),
+	}
+}
+
+// file return the coverage of the file, and create it if needed
+func (r *coverageReport) file(path string) *coverageFile {
+	f, ok := r.files[path]
+	if !ok {
+		f = &coverageFile{
+			path:   path,
+			lines:  make(map[int]int),
+			blocks: make(map[string]*goBlock),
+		}
+		r.files[path] = f
+	}
+
+	return f
+}
+
+// sortedFiles return the files sorted by path
+func (r *coverageReport) sortedFiles() []*coverageFile {
+	files := make([]*coverageFile, 0, len(r.files))
+	for _, f := range r.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+
+	return files
+}
+
+// merge add the hits of other report, after rewriting the paths
+func (r *coverageReport) merge(other *coverageReport, rewrites [][2]string) {
+	if other.mode != "" {
+		switch r.mode {
+		case "":
+			r.mode = other.mode
+		case other.mode:
+		default:
+			r.mode = "count"
+		}
+	}
+
+	for _, src := range other.files {
+		dst := r.file(rewritePath(src.path, rewrites))
+		if (len(src.blocks) == 0 && len(src.lines) > 0) || src.lineCoverage {
+			dst.lineCoverage = true
+		}
+		for line, hits := range src.lines {
+			dst.lines[line] += hits
+		}
+		for position, block := range src.blocks {
+			if existing, ok := dst.blocks[position]; ok {
+				existing.count += block.count
+				continue
+			}
+			dst.blocks[position] = &goBlock{position: block.position, numStmt: block.numStmt, count: block.count}
+		}
+	}
+}
+
+// sortedLines return the line numbers sorted
+func (f *coverageFile) sortedLines() []int {
+	lines := make([]int, 0, len(f.lines))
+	for line := range f.lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	return lines
+}
+
+// covered return the number of covered lines
+func (f *coverageFile) covered() int {
+	covered := 0
+	for _, hits := range f.lines {
+		if hits > 0 {
+			covered++
+		}
+	}
+
+	return covered
+}
+
+// rewritePath replace the first matching prefix
+func rewritePath(path string, rewrites [][2]string) string {
+	for _, rewrite := range rewrites {
+		if strings.HasPrefix(path, rewrite[0]) {
+			return rewrite[1] + strings.TrimPrefix(path, rewrite[0])
+		}
+	}
+
+	return path
+}
+
+// detectCoverageFormat return the format of the report, or empty string if unknown
+func detectCoverageFormat(content string) string {
+	content = strings.TrimLeft(content, "\ufeff \t\r\n")
+
+	switch {
+	case strings.HasPrefix(content, "mode:"):
+		return "go"
+	case strings.Contains(content, "<coverage"):
+		return "cobertura"
+	case strings.Contains(content, "<report"):
+		return "jacoco"
+	case strings.HasPrefix(content, "TN:") || strings.HasPrefix(content, "SF:") || strings.Contains(content, "\nSF:"):
+		return "lcov"
+	default:
+		return ""
+	}
+}
+
+// parseCoverage parse the report with the format
+func parseCoverage(content string, format string) (*coverageReport, error) {
+	switch format {
+	case "go":
+		return parseGoCoverage(content)
+	case "lcov":
+		return parseLcovCoverage(content)
+	case "cobertura":
+		return parseCoberturaCoverage(content)
+	case "jacoco":
+		return parseJacocoCoverage(content)
+	default:
+		return nil, errors.Errorf("Format %s not supported", format)
+	}
+}
+
+// renderCoverage render the report with the format
+func renderCoverage(report *coverageReport, format string) (string, error) {
+	switch format {
+	case "go":
+		return renderGoCoverage(report)
+	case "lcov":
+		return renderLcovCoverage(report), nil
+	case "cobertura":
+		return renderCoberturaCoverage(report)
+	case "jacoco":
+		return renderJacocoCoverage(report)
+	default:
+		return "", errors.Errorf("Format %s not supported", format)
+	}
+}
+
+// lineRate return the rate of covered lines, formated for XML reports
+func lineRate(covered int, valid int) string {
+	if valid == 0 {
+		return "0"
+	}
+
+	return fmt.Sprintf("%.4f", float64(covered)/float64(valid))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readFixture return the content of testdata file
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Error when read fixture %s: %s", name, err.Error())
+	}
+
+	return string(content)
+}
+
+// reportLines return the hits of each line by file
+func reportLines(report *coverageReport) map[string]map[int]int {
+	lines := make(map[string]map[int]int, len(report.files))
+	for path, f := range report.files {
+		lines[path] = f.lines
+	}
+
+	return lines
+}
+
+// coveredLines return the covered state of each line by file, as jacoco and set mode lose the hits count
+func coveredLines(report *coverageReport) map[string]map[int]bool {
+	lines := make(map[string]map[int]bool, len(report.files))
+	for path, f := range report.files {
+		lines[path] = make(map[int]bool, len(f.lines))
+		for line, hits := range f.lines {
+			lines[path][line] = hits > 0
+		}
+	}
+
+	return lines
+}
+
+func TestDetectCoverageFormat(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{fixture: "coverage.out", want: "go"},
+		{fixture: "lcov.info", want: "lcov"},
+		{fixture: "cobertura.xml", want: "cobertura"},
+		{fixture: "jacoco.xml", want: "jacoco"},
+		{fixture: "jacoco-groups.xml", want: "jacoco"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			if got := detectCoverageFormat(readFixture(t, test.fixture)); got != test.want {
+				t.Errorf("detectCoverageFormat() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCoverage(t *testing.T) {
+	tests := []struct {
+		fixture string
+		format  string
+		want    map[string]map[int]int
+	}{
+		{
+			fixture: "coverage.out",
+			format:  "go",
+			want: map[string]map[int]int{
+				"example.com/app/main.go":      {3: 1, 4: 1, 5: 1, 7: 0, 8: 0, 9: 0},
+				"example.com/app/util/util.go": {3: 3, 4: 3},
+			},
+		},
+		{
+			fixture: "lcov.info",
+			format:  "lcov",
+			want: map[string]map[int]int{
+				"src/index.js":    {1: 1, 2: 0, 4: 5},
+				"src/lib/util.js": {3: 2},
+			},
+		},
+		{
+			fixture: "cobertura.xml",
+			format:  "cobertura",
+			want: map[string]map[int]int{
+				"/src/app/main.py": {1: 1, 2: 0, 3: 4},
+			},
+		},
+		{
+			fixture: "jacoco.xml",
+			format:  "jacoco",
+			want: map[string]map[int]int{
+				"com/example/App.java": {3: 1, 5: 0},
+			},
+		},
+		{
+			fixture: "jacoco-groups.xml",
+			format:  "jacoco",
+			want: map[string]map[int]int{
+				"com/example/api/Api.java":   {10: 1},
+				"com/example/core/Core.java": {20: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			report, err := parseCoverage(readFixture(t, test.fixture), test.format)
+			if err != nil {
+				t.Fatalf("parseCoverage() error: %s", err.Error())
+			}
+			if got := reportLines(report); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseCoverage() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCoverageInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+	}{
+		{name: "go without mode", content: "example.com/app/main.go:3.13,5.2 2 1\n", format: "go"},
+		{name: "go invalid block", content: "mode: set\nexample.com/app/main.go 2 1\n", format: "go"},
+		{name: "lcov line outside record", content: "DA:1,1\n", format: "lcov"},
+		{name: "lcov invalid hits", content: "SF:a.js\nDA:1,x\n", format: "lcov"},
+		{name: "cobertura invalid xml", content: "<coverage>", format: "cobertura"},
+		{name: "jacoco invalid xml", content: "<report>", format: "jacoco"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseCoverage(test.content, test.format); err == nil {
+				t.Errorf("parseCoverage() need to return an error")
+			}
+		})
+	}
+}
+
+// TestRoundTrip render each fixture on each format, and parse it again
+func TestRoundTrip(t *testing.T) {
+	fixtures := map[string]string{
+		"coverage.out":      "go",
+		"lcov.info":         "lcov",
+		"cobertura.xml":     "cobertura",
+		"jacoco.xml":        "jacoco",
+		"jacoco-groups.xml": "jacoco",
+	}
+
+	for fixture, from := range fixtures {
+		for _, to := range coverageFormats {
+			t.Run(fixture+" to "+to, func(t *testing.T) {
+				parsed, err := parseCoverage(readFixture(t, fixture), from)
+				if err != nil {
+					t.Fatalf("parseCoverage() error: %s", err.Error())
+				}
+				report := newCoverageReport()
+				report.merge(parsed, nil)
+
+				content, err := renderCoverage(report, to)
+				if err != nil {
+					t.Fatalf("renderCoverage() error: %s", err.Error())
+				}
+				if got := detectCoverageFormat(content); got != to {
+					t.Errorf("detectCoverageFormat() of rendered report = %q, want %q", got, to)
+				}
+
+				roundTrip, err := parseCoverage(content, to)
+				if err != nil {
+					t.Fatalf("parseCoverage() of rendered report error: %s\n%s", err.Error(), content)
+				}
+
+				// Jacoco has no hits count
+				if to == "jacoco" || from == "jacoco" {
+					if got, want := coveredLines(roundTrip), coveredLines(report); !reflect.DeepEqual(got, want) {
+						t.Errorf("round trip = %v, want %v", got, want)
+					}
+					return
+				}
+				if got, want := reportLines(roundTrip), reportLines(report); !reflect.DeepEqual(got, want) {
+					t.Errorf("round trip = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// TestRenderGoCoverageKeepBlocks check that go blocks are rendered as is when converting go to go
+func TestRenderGoCoverageKeepBlocks(t *testing.T) {
+	content := readFixture(t, "coverage.out")
+	report, err := parseCoverage(content, "go")
+	if err != nil {
+		t.Fatalf("parseCoverage() error: %s", err.Error())
+	}
+
+	got, err := renderCoverage(report, "go")
+	if err != nil {
+		t.Fatalf("renderCoverage() error: %s", err.Error())
+	}
+	if got != content {
+		t.Errorf("renderCoverage() = %q, want %q", got, content)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first, err := parseCoverage("SF:/src/a.js\nDA:1,1\nDA:2,0\nend_of_record\n", "lcov")
+	if err != nil {
+		t.Fatalf("parseCoverage() error: %s", err.Error())
+	}
+	second, err := parseCoverage("SF:/build/a.js\nDA:2,3\nDA:3,0\nend_of_record\n", "lcov")
+	if err != nil {
+		t.Fatalf("parseCoverage() error: %s", err.Error())
+	}
+
+	report := newCoverageReport()
+	rewrites := [][2]string{{"/src/", ""}, {"/build/", ""}}
+	report.merge(first, rewrites)
+	report.merge(second, rewrites)
+
+	want := map[string]map[int]int{"a.js": {1: 1, 2: 3, 3: 0}}
+	if got := reportLines(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("merge() = %v, want %v", got, want)
+	}
+}
+
+// TestMergeGoAndLines check that line coverage is not silently dropped when rendering go coverprofile
+func TestMergeGoAndLines(t *testing.T) {
+	goReport, err := parseCoverage("mode: count\nexample.com/app/main.go:3.13,5.2 2 1\n", "go")
+	if err != nil {
+		t.Fatalf("parseCoverage() error: %s", err.Error())
+	}
+	lcovReport, err := parseCoverage("SF:example.com/app/main.go\nDA:10,2\nend_of_record\n", "lcov")
+	if err != nil {
+		t.Fatalf("parseCoverage() error: %s", err.Error())
+	}
+
+	report := newCoverageReport()
+	report.merge(goReport, nil)
+	report.merge(lcovReport, nil)
+
+	// Line formats can merge them
+	content, err := renderCoverage(report, "lcov")
+	if err != nil {
+		t.Fatalf("renderCoverage() error: %s", err.Error())
+	}
+	if !strings.Contains(content, "DA:3,1\n") || !strings.Contains(content, "DA:10,2\n") {
+		t.Errorf("renderCoverage() need to contain go and lcov lines, got %s", content)
+	}
+
+	// Go coverprofile can't
+	if _, err = renderCoverage(report, "go"); err == nil {
+		t.Errorf("renderCoverage() need to reject go blocks mixed with line coverage")
+	}
+}
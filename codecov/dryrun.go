@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"dagger/codecov/internal/dagger"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// uploadPayload is what would be sent to codecov on dry run mode
+type uploadPayload struct {
+	Command    []string         `json:"command"`
+	ReportType string           `json:"reportType,omitempty"`
+	Ci         *CiEnvironment   `json:"ci,omitempty"`
+	Files      []*reportPayload `json:"files"`
+}
+
+// reportPayload is the validation result of one report file
+type reportPayload struct {
+	Path    string `json:"path"`
+	Format  string `json:"format"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	Lines   int    `json:"lines,omitempty"`
+	Covered int    `json:"covered,omitempty"`
+	Tests   int    `json:"tests,omitempty"`
+}
+
+// junitXml is the root of JUnit report, testsuites or testsuite
+type junitXml struct {
+	XMLName xml.Name
+	Tests   int `xml:"tests,attr"`
+	Suites  []struct {
+		Tests int `xml:"tests,attr"`
+	} `xml:"testsuite"`
+}
+
+// dryRun validate the report files and return the payload as JSON, without calling codecov.
+// It fail when a report is invalid
+func (h *Codecov) dryRun(ctx context.Context, reportType string, files []*dagger.File, cmd []string) (string, error) {
+	payload := &uploadPayload{
+		Command:    h.command(cmd...),
+		ReportType: reportType,
+		Ci:         h.Ci,
+		Files:      make([]*reportPayload, 0, len(files)),
+	}
+
+	invalid := make([]string, 0)
+	for i, file := range files {
+		name, err := file.Name(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "Error when get report file name")
+		}
+		content, err := file.Contents(ctx)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error when read report %s", name)
+		}
+
+		report := &reportPayload{Path: fmt.Sprintf("%s/%d/%s", reportsPath, i, name)}
+		if reportType == "test_results" {
+			validateJunit(report, content)
+		} else {
+			validateCoverage(report, content)
+		}
+		if !report.Valid {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", name, report.Error))
+		}
+		payload.Files = append(payload.Files, report)
+	}
+
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "Error when encode dry run payload")
+	}
+
+	if len(invalid) > 0 {
+		return "", errors.Errorf("Invalid reports:\n%s\n\nPayload:\n%s", strings.Join(invalid, "\n"), data)
+	}
+
+	return string(data), nil
+}
+
+// validateCoverage parse the coverage report with its detected format.
+// The formats not known by the module are not validated
+func validateCoverage(report *reportPayload, content string) {
+	report.Format = detectCoverageFormat(content)
+	if report.Format == "" {
+		report.Format = "unknown"
+		report.Valid = true
+		return
+	}
+
+	parsed, err := parseCoverage(content, report.Format)
+	if err != nil {
+		report.Error = err.Error()
+		return
+	}
+	if len(parsed.files) == 0 {
+		report.Error = "the report has no file"
+		return
+	}
+
+	report.Valid = true
+	report.Files = len(parsed.files)
+	for _, f := range parsed.files {
+		report.Lines += len(f.lines)
+		report.Covered += f.covered()
+	}
+}
+
+// validateJunit parse the JUnit XML report
+func validateJunit(report *reportPayload, content string) {
+	report.Format = "junit"
+
+	data := &junitXml{}
+	if err := xml.Unmarshal([]byte(content), data); err != nil {
+		report.Error = err.Error()
+		return
+	}
+	if data.XMLName.Local != "testsuites" && data.XMLName.Local != "testsuite" {
+		report.Error = fmt.Sprintf("the root element is %s, it need to be testsuites or testsuite", data.XMLName.Local)
+		return
+	}
+
+	report.Valid = true
+	report.Tests = data.Tests
+	if report.Tests == 0 {
+		for _, suite := range data.Suites {
+			report.Tests += suite.Tests
+		}
+	}
+}
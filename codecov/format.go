@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+var goCoverageBlockRegexp = regexp.MustCompile(`^(.+):((\d+)\.\d+,(\d+)\.\d+) (\d+) (\d+)$`)
+
+// parseGoCoverage parse go coverprofile. A line hits is the max of the blocks that contain it
+func parseGoCoverage(content string) (*coverageReport, error) {
+	report := newCoverageReport()
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if mode, ok := strings.CutPrefix(line, "mode:"); ok {
+			report.mode = strings.TrimSpace(mode)
+			continue
+		}
+
+		match := goCoverageBlockRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.Errorf("Line %d is invalid: %s", i+1, line)
+		}
+		startLine, _ := strconv.Atoi(match[3])
+		endLine, _ := strconv.Atoi(match[4])
+		numStmt, _ := strconv.Atoi(match[5])
+		count, _ := strconv.Atoi(match[6])
+
+		f := report.file(match[1])
+		if block, ok := f.blocks[match[2]]; ok {
+			block.count += count
+		} else {
+			f.blocks[match[2]] = &goBlock{position: match[2], numStmt: numStmt, count: count}
+		}
+		for l := startLine; l <= endLine; l++ {
+			if hits, ok := f.lines[l]; !ok || count > hits {
+				f.lines[l] = count
+			}
+		}
+	}
+
+	if report.mode == "" {
+		return nil, errors.New("The mode line is missing")
+	}
+
+	return report, nil
+}
+
+// renderGoCoverage render go coverprofile. The files without go blocks get one block per line.
+// A file with go blocks and line coverage from another format can't be rendered without losing lines
+func renderGoCoverage(report *coverageReport) (string, error) {
+	mode := report.mode
+	if mode == "" {
+		mode = "count"
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "mode: %s\n", mode)
+
+	for _, f := range report.sortedFiles() {
+		if len(f.blocks) > 0 && f.lineCoverage {
+			return "", errors.Errorf("The file %s have go blocks and line coverage from another format, they can't be merged on go coverprofile", f.path)
+		}
+		if len(f.blocks) > 0 {
+			positions := make([]string, 0, len(f.blocks))
+			for position := range f.blocks {
+				positions = append(positions, position)
+			}
+			sort.Strings(positions)
+			for _, position := range positions {
+				block := f.blocks[position]
+				fmt.Fprintf(b, "%s:%s %d %d\n", f.path, position, block.numStmt, goCount(mode, block.count))
+			}
+			continue
+		}
+
+		// The block end on the same line, else it would cover the next line too
+		for _, line := range f.sortedLines() {
+			fmt.Fprintf(b, "%s:%d.1,%d.2 1 %d\n", f.path, line, line, goCount(mode, f.lines[line]))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// goCount return the count of block with the mode
+func goCount(mode string, count int) int {
+	if mode == "set" && count > 0 {
+		return 1
+	}
+
+	return count
+}
+
+// parseLcovCoverage parse lcov tracefile
+func parseLcovCoverage(content string) (*coverageReport, error) {
+	report := newCoverageReport()
+
+	var f *coverageFile
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			f = report.file(strings.TrimPrefix(line, "SF:"))
+		case strings.HasPrefix(line, "DA:"):
+			if f == nil {
+				return nil, errors.Errorf("Line %d is outside of a SF record", i+1)
+			}
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, errors.Errorf("Line %d is invalid: %s", i+1, line)
+			}
+			number, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Line %d is invalid: %s", i+1, line)
+			}
+			hits, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Line %d is invalid: %s", i+1, line)
+			}
+			f.lines[number] += hits
+		case line == "end_of_record":
+			f = nil
+		}
+	}
+
+	return report, nil
+}
+
+// renderLcovCoverage render lcov tracefile
+func renderLcovCoverage(report *coverageReport) string {
+	b := new(strings.Builder)
+
+	for _, f := range report.sortedFiles() {
+		b.WriteString("TN:\n")
+		fmt.Fprintf(b, "SF:%s\n", f.path)
+		for _, line := range f.sortedLines() {
+			fmt.Fprintf(b, "DA:%d,%d\n", line, f.lines[line])
+		}
+		fmt.Fprintf(b, "LF:%d\n", len(f.lines))
+		fmt.Fprintf(b, "LH:%d\n", f.covered())
+		b.WriteString("end_of_record\n")
+	}
+
+	return b.String()
+}
+
+type coberturaXml struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       string             `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// parseCoberturaCoverage parse cobertura XML. When there are one source, it's used as prefix of file names
+func parseCoberturaCoverage(content string) (*coverageReport, error) {
+	data := &coberturaXml{}
+	if err := xml.Unmarshal([]byte(content), data); err != nil {
+		return nil, err
+	}
+
+	source := ""
+	if len(data.Sources) == 1 && strings.TrimSpace(data.Sources[0]) != "." {
+		source = strings.TrimSpace(data.Sources[0])
+	}
+
+	report := newCoverageReport()
+	for _, p := range data.Packages {
+		for _, c := range p.Classes {
+			filename := c.Filename
+			if source != "" && !path.IsAbs(filename) {
+				filename = path.Join(source, filename)
+			}
+			f := report.file(filename)
+			for _, line := range c.Lines {
+				f.lines[line.Number] += line.Hits
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// renderCoberturaCoverage render cobertura XML, with one package per directory
+func renderCoberturaCoverage(report *coverageReport) (string, error) {
+	data := &coberturaXml{
+		BranchRate: "0",
+		Complexity: "0",
+		Timestamp:  "0",
+		Sources:    []string{"."},
+		Packages:   make([]coberturaPackage, 0),
+	}
+
+	packages := make(map[string]*coberturaPackage)
+	packageNames := make([]string, 0)
+	packageCounters := make(map[string][2]int)
+
+	for _, f := range report.sortedFiles() {
+		dir := path.Dir(f.path)
+		p, ok := packages[dir]
+		if !ok {
+			p = &coberturaPackage{
+				Name:       dir,
+				BranchRate: "0",
+				Complexity: "0",
+				Classes:    make([]coberturaClass, 0),
+			}
+			packages[dir] = p
+			packageNames = append(packageNames, dir)
+		}
+
+		c := coberturaClass{
+			Name:       path.Base(f.path),
+			Filename:   f.path,
+			LineRate:   lineRate(f.covered(), len(f.lines)),
+			BranchRate: "0",
+			Complexity: "0",
+			Lines:      make([]coberturaLine, 0, len(f.lines)),
+		}
+		for _, line := range f.sortedLines() {
+			c.Lines = append(c.Lines, coberturaLine{Number: line, Hits: f.lines[line]})
+		}
+		p.Classes = append(p.Classes, c)
+
+		counters := packageCounters[dir]
+		packageCounters[dir] = [2]int{counters[0] + f.covered(), counters[1] + len(f.lines)}
+		data.LinesCovered += f.covered()
+		data.LinesValid += len(f.lines)
+	}
+
+	for _, name := range packageNames {
+		p := packages[name]
+		p.LineRate = lineRate(packageCounters[name][0], packageCounters[name][1])
+		data.Packages = append(data.Packages, *p)
+	}
+	data.LineRate = lineRate(data.LinesCovered, data.LinesValid)
+
+	content, err := xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return xml.Header + `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">` + "\n" + string(content) + "\n", nil
+}
+
+type jacocoXml struct {
+	XMLName  xml.Name        `xml:"report"`
+	Name     string          `xml:"name,attr"`
+	Groups   []jacocoGroup   `xml:"group"`
+	Packages []jacocoPackage `xml:"package"`
+	Counters []jacocoCounter `xml:"counter"`
+}
+
+// jacocoGroup is a module of multi-module report, groups can be nested
+type jacocoGroup struct {
+	Name     string          `xml:"name,attr"`
+	Groups   []jacocoGroup   `xml:"group"`
+	Packages []jacocoPackage `xml:"package"`
+}
+
+// packages return the packages of the group and its nested groups
+func (g jacocoGroup) packages() []jacocoPackage {
+	packages := g.Packages
+	for _, group := range g.Groups {
+		packages = append(packages, group.packages()...)
+	}
+
+	return packages
+}
+
+type jacocoPackage struct {
+	Name        string             `xml:"name,attr"`
+	SourceFiles []jacocoSourceFile `xml:"sourcefile"`
+	Counters    []jacocoCounter    `xml:"counter"`
+}
+
+type jacocoSourceFile struct {
+	Name     string          `xml:"name,attr"`
+	Lines    []jacocoLine    `xml:"line"`
+	Counters []jacocoCounter `xml:"counter"`
+}
+
+type jacocoLine struct {
+	Nr int `xml:"nr,attr"`
+	Mi int `xml:"mi,attr"`
+	Ci int `xml:"ci,attr"`
+	Mb int `xml:"mb,attr"`
+	Cb int `xml:"cb,attr"`
+}
+
+type jacocoCounter struct {
+	Type    string `xml:"type,attr"`
+	Missed  int    `xml:"missed,attr"`
+	Covered int    `xml:"covered,attr"`
+}
+
+// parseJacocoCoverage parse jacoco XML, with the packages of all groups. Jacoco has no hits count, so a covered line has one hit
+func parseJacocoCoverage(content string) (*coverageReport, error) {
+	data := &jacocoXml{}
+	if err := xml.Unmarshal([]byte(content), data); err != nil {
+		return nil, err
+	}
+
+	report := newCoverageReport()
+	root := jacocoGroup{Groups: data.Groups, Packages: data.Packages}
+	for _, p := range root.packages() {
+		for _, s := range p.SourceFiles {
+			f := report.file(path.Join(p.Name, s.Name))
+			for _, line := range s.Lines {
+				if line.Ci > 0 {
+					f.lines[line.Nr]++
+				} else if _, ok := f.lines[line.Nr]; !ok {
+					f.lines[line.Nr] = 0
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// renderJacocoCoverage render jacoco XML, with one package per directory and line counters only
+func renderJacocoCoverage(report *coverageReport) (string, error) {
+	data := &jacocoXml{
+		Name:     "coverage",
+		Packages: make([]jacocoPackage, 0),
+	}
+
+	packages := make(map[string]*jacocoPackage)
+	packageNames := make([]string, 0)
+	total := jacocoCounter{Type: "LINE"}
+
+	for _, f := range report.sortedFiles() {
+		dir := path.Dir(f.path)
+		if dir == "." {
+			dir = ""
+		}
+		p, ok := packages[dir]
+		if !ok {
+			p = &jacocoPackage{
+				Name:        dir,
+				SourceFiles: make([]jacocoSourceFile, 0),
+				Counters:    []jacocoCounter{{Type: "LINE"}},
+			}
+			packages[dir] = p
+			packageNames = append(packageNames, dir)
+		}
+
+		s := jacocoSourceFile{
+			Name:     path.Base(f.path),
+			Lines:    make([]jacocoLine, 0, len(f.lines)),
+			Counters: []jacocoCounter{{Type: "LINE", Missed: len(f.lines) - f.covered(), Covered: f.covered()}},
+		}
+		for _, line := range f.sortedLines() {
+			if f.lines[line] > 0 {
+				s.Lines = append(s.Lines, jacocoLine{Nr: line, Ci: 1})
+			} else {
+				s.Lines = append(s.Lines, jacocoLine{Nr: line, Mi: 1})
+			}
+		}
+		p.SourceFiles = append(p.SourceFiles, s)
+
+		p.Counters[0].Missed += s.Counters[0].Missed
+		p.Counters[0].Covered += s.Counters[0].Covered
+		total.Missed += s.Counters[0].Missed
+		total.Covered += s.Counters[0].Covered
+	}
+
+	for _, name := range packageNames {
+		data.Packages = append(data.Packages, *packages[name])
+	}
+	data.Counters = []jacocoCounter{total}
+
+	content, err := xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return xml.Header + `<!DOCTYPE report PUBLIC "-//JACOCO//DTD Report 1.1//EN" "report.dtd">` + "\n" + string(content) + "\n", nil
+}
@@ -18,34 +18,49 @@ import (
 	"context"
 	"dagger/codecov/internal/dagger"
 	"fmt"
-	"strings"
+	"strconv"
+
+	"emperror.dev/errors"
 )
 
+const reportsPath = "/tmp/codecov/reports"
+
 type Codecov struct {
 	// The container
 	Container *dagger.Container
+
+	// The codecov URL, for self hosted codecov
+	// +private
+	Url string
+
+	// The CI context detected from the host environment
+	// +private
+	Ci *CiEnvironment
+
+	// +private
+	DryRun bool
 }
 
 // New initializes the golang dagger module
 func New(
 	ctx context.Context,
-	// A custom base image containing a codecov uploader
+	// A custom base image containing codecovcli
 	// +optional
 	base *dagger.Container,
-	// The golang version to use when no go.mod
+	// The codecov-cli version to use. The binary is verified against the Codecov signature
 	// +optional
 	version string,
 ) (*Codecov, error) {
 
 	var (
-		codeCov    *dagger.Container
-		urlCodecov string
+		codeCov        *dagger.Container
+		versionCodecov string
 	)
 
 	if version != "" {
-		urlCodecov = fmt.Sprintf("https://uploader.codecov.io/v%s/linux/codecov", version)
+		versionCodecov = fmt.Sprintf("v%s", version)
 	} else {
-		urlCodecov = "https://uploader.codecov.io/latest/linux/codecov"
+		versionCodecov = "latest"
 	}
 
 	if base != nil {
@@ -53,10 +68,9 @@ func New(
 	} else {
 		codeCov = dag.Container().
 			From("cgr.dev/chainguard/wolfi-base").
-			WithExec([]string{"apk", "add", "curl", "git"}).
-			WithExec([]string{"curl", "-o", "/bin/codecov", "-s", urlCodecov}).
-			WithExec([]string{"chmod", "+x", "/bin/codecov"}).
-			WithExec([]string{"ls", "-lah", "/bin/codecov"})
+			WithExec([]string{"apk", "add", "curl", "git", "gnupg"})
+		codeCov = installVerifiedBinary(codeCov, "https://cli.codecov.io", "codecov", versionCodecov, "/bin/codecovcli").
+			WithExec([]string{"codecovcli", "--version"})
 	}
 
 	codeCov = codeCov.
@@ -73,41 +87,375 @@ func (h *Codecov) WithContainer(ctn *dagger.Container) *Codecov {
 	return h
 }
 
+// WithUrl permit to use a self hosted codecov
+func (h *Codecov) WithUrl(url string) *Codecov {
+	h.Url = url
+	return h
+}
+
+// Upload upload coverage reports with codecovcli upload-process
 func (h *Codecov) Upload(
 	ctx context.Context,
 
-	// The source directory
+	// The source directory, with the .git directory
 	src *dagger.Directory,
 
-	// The codecov token
+	// The codecov token. Not needed for tokenless upload on public repositories
+	// +optional
 	token *dagger.Secret,
 
+	// An OIDC ID token to use instead of the codecov token, like the one provided by Github Actions or Gitlab CI id_tokens
+	// +optional
+	oidcToken *dagger.Secret,
+
+	// The upload name
+	// +optional
+	name string,
+
+	// The coverage report files
+	// +optional
+	files []*dagger.File,
+
+	// The codecov flags
+	// +optional
+	flags []string,
+
+	// The commit SHA. Default to the current commit of the source directory
+	// +optional
+	sha string,
+
+	// The branch name. Default to the current branch of the source directory
 	// +optional
-	name string, // optional name
+	branch string,
 
+	// The pull request number
 	// +optional
-	files []string, // optional list of coverage files
+	pr int,
 
+	// The repository slug, like owner/repo
 	// +optional
-	flags []string, // optional additional flags for uploader
+	slug string,
+
+	// The git service, like github, gitlab or bitbucket
+	// +optional
+	gitService string,
+
+	// Set true to only upload the given files, without searching reports on the source directory
+	// +optional
+	disableSearch bool,
+
+	// Set true to fail when the upload fail
+	// +optional
+	failOnError bool,
+
+	// Additional arguments for codecovcli upload-process
+	// +optional
+	args []string,
 ) (string, error) {
-	cmd := []string{"/bin/codecov", "-t", "$CODECOV_TOKEN", "-v"}
+	return h.uploadProcess(ctx, "coverage", src, token, oidcToken, name, files, flags, sha, branch, pr, slug, gitService, disableSearch, failOnError, args)
+}
 
-	if name != "" {
-		cmd = append(cmd, "-n", name)
+// UploadTestResults upload JUnit test results with codecovcli upload-process, to use codecov test analytics
+func (h *Codecov) UploadTestResults(
+	ctx context.Context,
+
+	// The source directory, with the .git directory
+	src *dagger.Directory,
+
+	// The JUnit XML report files
+	files []*dagger.File,
+
+	// The codecov token. Not needed for tokenless upload on public repositories
+	// +optional
+	token *dagger.Secret,
+
+	// An OIDC ID token to use instead of the codecov token, like the one provided by Github Actions or Gitlab CI id_tokens
+	// +optional
+	oidcToken *dagger.Secret,
+
+	// The upload name
+	// +optional
+	name string,
+
+	// The codecov flags
+	// +optional
+	flags []string,
+
+	// The commit SHA. Default to the current commit of the source directory
+	// +optional
+	sha string,
+
+	// The branch name. Default to the current branch of the source directory
+	// +optional
+	branch string,
+
+	// The pull request number
+	// +optional
+	pr int,
+
+	// The repository slug, like owner/repo
+	// +optional
+	slug string,
+
+	// The git service, like github, gitlab or bitbucket
+	// +optional
+	gitService string,
+
+	// Set true to fail when the upload fail
+	// +optional
+	failOnError bool,
+) (string, error) {
+	return h.uploadProcess(ctx, "test_results", src, token, oidcToken, name, files, flags, sha, branch, pr, slug, gitService, true, failOnError, nil)
+}
+
+// CreateCommit create the commit on codecov. It's only needed when upload is splitted on create-commit, create-report and do-upload
+func (h *Codecov) CreateCommit(
+	ctx context.Context,
+
+	// The source directory, with the .git directory
+	src *dagger.Directory,
+
+	// The codecov token. Not needed for tokenless upload on public repositories
+	// +optional
+	token *dagger.Secret,
+
+	// An OIDC ID token to use instead of the codecov token
+	// +optional
+	oidcToken *dagger.Secret,
+
+	// The commit SHA. Default to the current commit of the source directory
+	// +optional
+	sha string,
+
+	// The parent commit SHA
+	// +optional
+	parentSha string,
+
+	// The branch name. Default to the current branch of the source directory
+	// +optional
+	branch string,
+
+	// The pull request number
+	// +optional
+	pr int,
+
+	// The repository slug, like owner/repo
+	// +optional
+	slug string,
+
+	// The git service, like github, gitlab or bitbucket
+	// +optional
+	gitService string,
+) (string, error) {
+	sha, branch, pr, slug, gitService = h.withCi(sha, branch, pr, slug, gitService)
+	cmd := []string{"create-commit", "--fail-on-error"}
+	cmd = append(cmd, commitArgs(sha, branch, pr, slug, gitService)...)
+	if parentSha != "" {
+		cmd = append(cmd, "--parent-sha", parentSha)
 	}
 
-	if len(files) > 0 {
-		cmd = append(cmd, "-f")
-		cmd = append(cmd, files...)
+	if h.DryRun {
+		return h.dryRun(ctx, "", nil, cmd)
+	}
+
+	return h.withToken(token, oidcToken).
+		WithDirectory("/project", src).
+		WithExec(h.command(cmd...)).
+		Stdout(ctx)
+}
+
+// CreateReport create the report on codecov for a commit
+func (h *Codecov) CreateReport(
+	ctx context.Context,
+
+	// The source directory, with the .git directory
+	src *dagger.Directory,
+
+	// The codecov token. Not needed for tokenless upload on public repositories
+	// +optional
+	token *dagger.Secret,
+
+	// An OIDC ID token to use instead of the codecov token
+	// +optional
+	oidcToken *dagger.Secret,
+
+	// The commit SHA. Default to the current commit of the source directory
+	// +optional
+	sha string,
+
+	// The report code
+	// +optional
+	// +default="default"
+	code string,
+
+	// The pull request number
+	// +optional
+	pr int,
+
+	// The repository slug, like owner/repo
+	// +optional
+	slug string,
+
+	// The git service, like github, gitlab or bitbucket
+	// +optional
+	gitService string,
+) (string, error) {
+	if code == "" {
+		code = "default"
 	}
+	sha, _, pr, slug, gitService = h.withCi(sha, "", pr, slug, gitService)
+	cmd := []string{"create-report", "--fail-on-error", "--code", code}
+	cmd = append(cmd, commitArgs(sha, "", pr, slug, gitService)...)
 
-	if len(flags) > 0 {
-		cmd = append(cmd, flags...)
+	if h.DryRun {
+		return h.dryRun(ctx, "", nil, cmd)
 	}
 
-	return h.Container.
+	return h.withToken(token, oidcToken).
 		WithDirectory("/project", src).
-		WithSecretVariable("CODECOV_TOKEN", token).
-		WithExec([]string{"sh", "-c", strings.Join(cmd, " ")}).Stdout(ctx)
+		WithExec(h.command(cmd...)).
+		Stdout(ctx)
+}
+
+// uploadProcess run codecovcli upload-process for the report type
+func (h *Codecov) uploadProcess(
+	ctx context.Context,
+	reportType string,
+	src *dagger.Directory,
+	token *dagger.Secret,
+	oidcToken *dagger.Secret,
+	name string,
+	files []*dagger.File,
+	flags []string,
+	sha string,
+	branch string,
+	pr int,
+	slug string,
+	gitService string,
+	disableSearch bool,
+	failOnError bool,
+	args []string,
+) (string, error) {
+	ctr := h.withToken(token, oidcToken).
+		WithDirectory("/project", src)
+
+	sha, branch, pr, slug, gitService = h.withCi(sha, branch, pr, slug, gitService)
+	cmd := []string{"upload-process", "--report-type", reportType}
+	cmd = append(cmd, commitArgs(sha, branch, pr, slug, gitService)...)
+
+	if h.Ci != nil {
+		if h.Ci.Build != "" {
+			cmd = append(cmd, "--build", h.Ci.Build)
+		}
+		if h.Ci.BuildUrl != "" {
+			cmd = append(cmd, "--build-url", h.Ci.BuildUrl)
+		}
+		if h.Ci.Job != "" {
+			cmd = append(cmd, "--job-code", h.Ci.Job)
+		}
+	}
+
+	if name != "" {
+		cmd = append(cmd, "--name", name)
+	}
+
+	for _, flag := range flags {
+		cmd = append(cmd, "--flag", flag)
+	}
+
+	for i, file := range files {
+		fileName, err := file.Name(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "Error when get report file name")
+		}
+		path := fmt.Sprintf("%s/%d/%s", reportsPath, i, fileName)
+		ctr = ctr.WithMountedFile(path, file)
+		cmd = append(cmd, "--file", path)
+	}
+
+	if disableSearch {
+		cmd = append(cmd, "--disable-search")
+	}
+
+	if failOnError {
+		cmd = append(cmd, "--fail-on-error")
+	}
+
+	cmd = append(cmd, args...)
+
+	if h.DryRun {
+		return h.dryRun(ctx, reportType, files, cmd)
+	}
+
+	return ctr.
+		WithExec(h.command(cmd...)).
+		Stdout(ctx)
+}
+
+// withToken return the container with the codecov token or the OIDC token
+func (h *Codecov) withToken(token *dagger.Secret, oidcToken *dagger.Secret) *dagger.Container {
+	if oidcToken != nil {
+		return h.Container.WithSecretVariable("CODECOV_TOKEN", oidcToken)
+	}
+	if token != nil {
+		return h.Container.WithSecretVariable("CODECOV_TOKEN", token)
+	}
+
+	return h.Container
+}
+
+// command return the codecovcli command with the global options
+func (h *Codecov) command(args ...string) []string {
+	cmd := []string{"codecovcli", "--verbose"}
+	if h.Url != "" {
+		cmd = append(cmd, "--enterprise-url", h.Url)
+	}
+
+	return append(cmd, args...)
+}
+
+// withCi return the commit values, completed with the CI context when they are not set
+func (h *Codecov) withCi(sha string, branch string, pr int, slug string, gitService string) (string, string, int, string, string) {
+	if h.Ci == nil {
+		return sha, branch, pr, slug, gitService
+	}
+	if sha == "" {
+		sha = h.Ci.Sha
+	}
+	if branch == "" {
+		branch = h.Ci.Branch
+	}
+	if pr == 0 {
+		pr = h.Ci.Pr
+	}
+	if slug == "" {
+		slug = h.Ci.Slug
+	}
+	if gitService == "" {
+		gitService = h.Ci.GitService
+	}
+
+	return sha, branch, pr, slug, gitService
+}
+
+// commitArgs return the codecovcli arguments that identify the commit
+func commitArgs(sha string, branch string, pr int, slug string, gitService string) []string {
+	args := make([]string, 0)
+	if sha != "" {
+		args = append(args, "--sha", sha)
+	}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	if pr > 0 {
+		args = append(args, "--pr", strconv.Itoa(pr))
+	}
+	if slug != "" {
+		args = append(args, "--slug", slug)
+	}
+	if gitService != "" {
+		args = append(args, "--git-service", gitService)
+	}
+
+	return args
 }
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"dagger/codecov/internal/dagger"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+const (
+	coverageReportDir       = "/tmp/coverage"
+	coverageHtmlDir         = "/tmp/coverage-html"
+	sonarScannerImage       = "sonarsource/sonar-scanner-cli:11.1"
+	reportGeneratorImage    = "mcr.microsoft.com/dotnet/sdk:8.0"
+	reportGeneratorVersion  = "5.4.1"
+	golangImage             = "golang:1.23"
+	coverallsReporterUrl    = "https://github.com/coverallsapp/coverage-reporter/releases/download/" + coverallsVersion
+	coverallsVersion        = "v0.6.15"
+	coverallsImage          = "cgr.dev/chainguard/wolfi-base"
+	defaultCoverallsUrl     = "https://coveralls.io"
+	defaultCoverageBackend  = "codecov"
+	defaultCoverageFormat   = "go"
+	coverageSummaryFileName = "SummaryGithub.md"
+	sonarGenericFileName    = "sonar-coverage.xml"
+)
+
+// The coverage formats supported by the publisher
+var coverageFormats = []string{"go", "lcov", "cobertura", "jacoco"}
+
+// CoveragePublisher publish coverage reports on a backend: codecov, coveralls, sonarqube or local
+type CoveragePublisher struct {
+	// The backend name
+	Backend string
+
+	// +private
+	Codecov *Codecov
+
+	// +private
+	Src *dagger.Directory
+
+	// +private
+	Token *dagger.Secret
+
+	// +private
+	Url string
+
+	// +private
+	ProjectKey string
+
+	// +private
+	Name string
+
+	// +private
+	Flags []string
+}
+
+// CoverageUpload is the result of CoveragePublisher.Upload
+type CoverageUpload struct {
+	// The backend name
+	Backend string
+
+	// The backend output
+	Output string
+
+	// The HTML report, only with local backend
+	Report *dagger.Directory
+
+	// The markdown summary, only with local backend. It's only returned, the caller need to post it
+	// on pull request or on job summary, like with dagger call ... summary >> $GITHUB_STEP_SUMMARY
+	Summary string
+}
+
+// coverageBackend upload a coverage report on a service
+type coverageBackend interface {
+	// Upload upload the report with the format
+	Upload(ctx context.Context, report *dagger.File, format string) (*CoverageUpload, error)
+}
+
+// Publisher return a coverage publisher for the backend
+func (h *Codecov) Publisher(
+	// The source directory, with the .git directory
+	src *dagger.Directory,
+
+	// The backend: codecov, coveralls, sonarqube or local
+	// +optional
+	// +default="codecov"
+	backend string,
+
+	// The backend token. Codecov token, coveralls repo token or sonarqube token
+	// +optional
+	token *dagger.Secret,
+
+	// The backend URL. Needed for sonarqube, optional for codecov and coveralls
+	// +optional
+	url string,
+
+	// The sonarqube project key
+	// +optional
+	projectKey string,
+
+	// The upload name. Codecov upload name or coveralls job flag
+	// +optional
+	name string,
+
+	// The codecov flags
+	// +optional
+	flags []string,
+) *CoveragePublisher {
+	if backend == "" {
+		backend = defaultCoverageBackend
+	}
+
+	return &CoveragePublisher{
+		Backend:    backend,
+		Codecov:    h,
+		Src:        src,
+		Token:      token,
+		Url:        url,
+		ProjectKey: projectKey,
+		Name:       name,
+		Flags:      flags,
+	}
+}
+
+// Upload upload the coverage report on the backend
+func (h *CoveragePublisher) Upload(
+	ctx context.Context,
+
+	// The coverage report
+	report *dagger.File,
+
+	// The report format: go, lcov, cobertura or jacoco
+	// +optional
+	// +default="go"
+	format string,
+) (*CoverageUpload, error) {
+	if format == "" {
+		format = defaultCoverageFormat
+	}
+	if !slices.Contains(coverageFormats, format) {
+		return nil, errors.Errorf("Format %s not supported, it need to be one of %s", format, strings.Join(coverageFormats, ", "))
+	}
+
+	backend, err := h.backend()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.Upload(ctx, report, format)
+}
+
+// backend return the backend implementation
+func (h *CoveragePublisher) backend() (coverageBackend, error) {
+	switch h.Backend {
+	case "codecov":
+		return &codecovBackend{h}, nil
+	case "coveralls":
+		return &coverallsBackend{h}, nil
+	case "sonarqube":
+		if h.Url == "" || h.ProjectKey == "" {
+			return nil, errors.New("The url and projectKey are required with sonarqube backend")
+		}
+		return &sonarqubeBackend{h}, nil
+	case "local":
+		return &localBackend{h}, nil
+	default:
+		return nil, errors.Errorf("Backend %s not supported, it need to be codecov, coveralls, sonarqube or local", h.Backend)
+	}
+}
+
+// withReport mount the report on the container and return its path
+func withReport(ctx context.Context, ctr *dagger.Container, report *dagger.File) (*dagger.Container, string, error) {
+	name, err := report.Name(ctx)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Error when get report file name")
+	}
+	path := fmt.Sprintf("%s/%s", coverageReportDir, name)
+
+	return ctr.WithMountedFile(path, report), path, nil
+}
+
+// codecovBackend upload the report with codecovcli
+type codecovBackend struct {
+	*CoveragePublisher
+}
+
+func (h *codecovBackend) Upload(ctx context.Context, report *dagger.File, format string) (*CoverageUpload, error) {
+	codecov := h.Codecov
+	if h.Url != "" {
+		withUrl := *h.Codecov
+		withUrl.Url = h.Url
+		codecov = &withUrl
+	}
+
+	output, err := codecov.Upload(ctx, h.Src, h.Token, nil, h.Name, []*dagger.File{report}, h.Flags, "", "", 0, "", "", true, true, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when upload coverage on codecov")
+	}
+
+	return &CoverageUpload{
+		Backend: h.Backend,
+		Output:  output,
+		Report:  dag.Directory(),
+	}, nil
+}
+
+// coverallsBackend upload the report with the coveralls universal coverage reporter
+type coverallsBackend struct {
+	*CoveragePublisher
+}
+
+func (h *coverallsBackend) Upload(ctx context.Context, report *dagger.File, format string) (*CoverageUpload, error) {
+	url := h.Url
+	if url == "" {
+		url = defaultCoverallsUrl
+	}
+	if format == "go" {
+		format = "golang"
+	}
+
+	// The reporter need curl, tar and sha256sum, so it use its own container and not the codecov one
+	ctr := dag.Container().
+		From(coverallsImage).
+		WithExec([]string{"apk", "add", "curl", "git", "tar", "coreutils"}).
+		WithExec([]string{"sh", "-c", fmt.Sprintf(`
+set -e
+cd /tmp
+curl -sSfL -o coveralls-linux.tar.gz %[1]s/coveralls-linux.tar.gz
+curl -sSfL -o coveralls-checksums.txt %[1]s/coveralls-checksums.txt
+if ! grep " coveralls-linux.tar.gz$" coveralls-checksums.txt | sha256sum -c; then
+	echo "ERROR: the checksum of coveralls-linux.tar.gz does not match" >&2
+	exit 1
+fi
+tar -xzf coveralls-linux.tar.gz -C /usr/local/bin coveralls
+rm coveralls-linux.tar.gz coveralls-checksums.txt
+`, coverallsReporterUrl)}).
+		WithDirectory("/project", h.Src).
+		WithWorkdir("/project").
+		WithEnvVariable("COVERALLS_ENDPOINT", url)
+	if h.Token != nil {
+		ctr = ctr.WithSecretVariable("COVERALLS_REPO_TOKEN", h.Token)
+	}
+
+	// The CI environment is not on this container, so forward the detected CI context
+	if ci := h.Codecov.Ci; ci != nil {
+		for _, env := range [][2]string{
+			{"COVERALLS_GIT_COMMIT", ci.Sha},
+			{"COVERALLS_GIT_BRANCH", ci.Branch},
+			{"COVERALLS_SERVICE_NUMBER", ci.Build},
+			{"COVERALLS_SERVICE_JOB_ID", ci.Job},
+		} {
+			if env[1] != "" {
+				ctr = ctr.WithEnvVariable(env[0], env[1])
+			}
+		}
+		if ci.Pr > 0 {
+			ctr = ctr.WithEnvVariable("COVERALLS_PULL_REQUEST", strconv.Itoa(ci.Pr))
+		}
+	}
+
+	ctr, path, err := withReport(ctx, ctr, report)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := []string{"coveralls", "report", path, "--format", format}
+	if h.Name != "" {
+		cmd = append(cmd, "--job-flag", h.Name)
+	}
+
+	output, err := ctr.WithExec(cmd).Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when upload coverage on coveralls")
+	}
+
+	return &CoverageUpload{
+		Backend: h.Backend,
+		Output:  output,
+		Report:  dag.Directory(),
+	}, nil
+}
+
+// sonarqubeBackend run sonar-scanner with the coverage report
+type sonarqubeBackend struct {
+	*CoveragePublisher
+}
+
+func (h *sonarqubeBackend) Upload(ctx context.Context, report *dagger.File, format string) (*CoverageUpload, error) {
+	reportProperties := map[string]string{
+		"go":        "sonar.go.coverage.reportPaths",
+		"lcov":      "sonar.javascript.lcov.reportPaths",
+		"cobertura": "sonar.coverageReportPaths",
+		"jacoco":    "sonar.coverage.jacoco.xmlReportPaths",
+	}
+
+	ctr := dag.Container().
+		From(sonarScannerImage).
+		WithDirectory("/usr/src", h.Src).
+		WithWorkdir("/usr/src")
+	if h.Token != nil {
+		ctr = ctr.WithSecretVariable("SONAR_TOKEN", h.Token)
+	}
+
+	// Sonar only read cobertura for Python, so it's converted to the generic format that work with any language
+	if format == "cobertura" {
+		content, err := report.Contents(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when read coverage report")
+		}
+		parsed, err := parseCoberturaCoverage(content)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when parse cobertura coverage report")
+		}
+		if content, err = renderSonarGenericCoverage(parsed); err != nil {
+			return nil, errors.Wrap(err, "Error when render sonar generic coverage report")
+		}
+		report = dag.Directory().
+			WithNewFile(sonarGenericFileName, content).
+			File(sonarGenericFileName)
+	}
+
+	ctr, path, err := withReport(ctx, ctr, report)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := ctr.
+		WithExec([]string{
+			"sonar-scanner",
+			fmt.Sprintf("-Dsonar.host.url=%s", h.Url),
+			fmt.Sprintf("-Dsonar.projectKey=%s", h.ProjectKey),
+			"-Dsonar.projectBaseDir=/usr/src",
+			fmt.Sprintf("-D%s=%s", reportProperties[format], path),
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when upload coverage on sonarqube")
+	}
+
+	return &CoverageUpload{
+		Backend: h.Backend,
+		Output:  output,
+		Report:  dag.Directory(),
+	}, nil
+}
+
+type sonarGenericXml struct {
+	XMLName xml.Name           `xml:"coverage"`
+	Version string             `xml:"version,attr"`
+	Files   []sonarGenericFile `xml:"file"`
+}
+
+type sonarGenericFile struct {
+	Path  string             `xml:"path,attr"`
+	Lines []sonarGenericLine `xml:"lineToCover"`
+}
+
+type sonarGenericLine struct {
+	LineNumber int  `xml:"lineNumber,attr"`
+	Covered    bool `xml:"covered,attr"`
+}
+
+// renderSonarGenericCoverage render the sonar generic test coverage XML
+func renderSonarGenericCoverage(report *coverageReport) (string, error) {
+	data := &sonarGenericXml{
+		Version: "1",
+		Files:   make([]sonarGenericFile, 0, len(report.files)),
+	}
+
+	for _, f := range report.sortedFiles() {
+		file := sonarGenericFile{
+			Path:  f.path,
+			Lines: make([]sonarGenericLine, 0, len(f.lines)),
+		}
+		for _, line := range f.sortedLines() {
+			file.Lines = append(file.Lines, sonarGenericLine{LineNumber: line, Covered: f.lines[line] > 0})
+		}
+		data.Files = append(data.Files, file)
+	}
+
+	content, err := xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return xml.Header + string(content) + "\n", nil
+}
+
+// localBackend render the HTML report and the markdown summary without sending anything.
+// The summary is only returned, it's not posted
+type localBackend struct {
+	*CoveragePublisher
+}
+
+func (h *localBackend) Upload(ctx context.Context, report *dagger.File, format string) (*CoverageUpload, error) {
+	if format == "go" {
+		return h.goReport(ctx, report)
+	}
+
+	ctr := dag.Container().
+		From(reportGeneratorImage).
+		WithExec([]string{"dotnet", "tool", "install", "--tool-path", "/tools", "dotnet-reportgenerator-globaltool", "--version", reportGeneratorVersion}).
+		WithDirectory("/src", h.Src)
+
+	ctr, path, err := withReport(ctx, ctr, report)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctr.WithExec([]string{
+		"/tools/reportgenerator",
+		fmt.Sprintf("-reports:%s", path),
+		fmt.Sprintf("-targetdir:%s", coverageHtmlDir),
+		"-sourcedirs:/src",
+		"-reporttypes:Html;MarkdownSummaryGithub",
+	})
+
+	output, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when generate coverage report")
+	}
+
+	summary, err := ctr.File(fmt.Sprintf("%s/%s", coverageHtmlDir, coverageSummaryFileName)).Contents(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when read coverage summary")
+	}
+
+	return &CoverageUpload{
+		Backend: h.Backend,
+		Output:  output,
+		Report:  ctr.Directory(coverageHtmlDir).WithoutFile(coverageSummaryFileName),
+		Summary: summary,
+	}, nil
+}
+
+// goReport render go coverprofile with go tool cover, it need the go module on source directory
+func (h *localBackend) goReport(ctx context.Context, report *dagger.File) (*CoverageUpload, error) {
+	ctr := dag.Container().
+		From(golangImage).
+		WithDirectory("/src", h.Src).
+		WithWorkdir("/src")
+
+	ctr, path, err := withReport(ctx, ctr, report)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctr.
+		WithExec([]string{"mkdir", "-p", coverageHtmlDir}).
+		WithExec([]string{"go", "tool", "cover", fmt.Sprintf("-html=%s", path), "-o", fmt.Sprintf("%s/index.html", coverageHtmlDir)})
+
+	output, err := ctr.
+		WithExec([]string{"go", "tool", "cover", fmt.Sprintf("-func=%s", path)}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when generate coverage report")
+	}
+
+	return &CoverageUpload{
+		Backend: h.Backend,
+		Output:  output,
+		Report:  ctr.Directory(coverageHtmlDir),
+		Summary: goCoverageSummary(output),
+	}, nil
+}
+
+// goCoverageSummary convert go tool cover -func output to markdown
+func goCoverageSummary(output string) string {
+	total := "unknown"
+	functions := new(strings.Builder)
+	functions.WriteString("| Function | Coverage |\n|---|---|\n")
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		if fields[0] == "total:" {
+			total = fields[len(fields)-1]
+			continue
+		}
+		fmt.Fprintf(functions, "| `%s %s` | %s |\n", strings.TrimSuffix(fields[0], ":"), fields[1], fields[len(fields)-1])
+	}
+
+	return fmt.Sprintf("## Coverage summary\n\n**Total coverage: %s**\n\n<details>\n<summary>Functions</summary>\n\n%s\n</details>\n", total, functions.String())
+}
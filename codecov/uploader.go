@@ -0,0 +1,73 @@
+package main
+
+import (
+	"dagger/codecov/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// The Codecov public key used to sign the SHA256SUM files
+	// https://docs.codecov.com/docs/codecov-uploader#integrity-checking-the-uploader
+	codecovPgpKeyUrl         = "https://keybase.io/codecovsecurity/pgp_keys.asc"
+	codecovPgpKeyFingerprint = "27034E7FDB850E0BBC2C62FF806BB28AED779869"
+)
+
+var cacheKeyInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// installVerifiedBinary download a Codecov binary with its SHA256SUM and signature, and install it
+// only if the signature match the Codecov public key and the checksum match the binary.
+// When the version is pinned, the verified files are kept on a cache volume and verified again on each use.
+func installVerifiedBinary(ctr *dagger.Container, baseUrl string, name string, version string, dst string) *dagger.Container {
+	url := fmt.Sprintf("%s/%s/linux/%s", baseUrl, version, name)
+
+	script := fmt.Sprintf(`
+set -eu
+work=/tmp/codecov-verify
+cache=/cache/%[2]s
+mkdir -p ${work}
+cd ${work}
+
+if [ -f ${cache}/%[2]s ] && [ -f ${cache}/%[2]s.SHA256SUM ] && [ -f ${cache}/%[2]s.SHA256SUM.sig ] && [ -f ${cache}/pgp_keys.asc ]; then
+	echo "Use %[2]s %[3]s from cache"
+	cp ${cache}/%[2]s ${cache}/%[2]s.SHA256SUM ${cache}/%[2]s.SHA256SUM.sig ${cache}/pgp_keys.asc .
+else
+	curl -sSfL -o pgp_keys.asc %[4]s
+	curl -sSfL -o %[2]s %[1]s
+	curl -sSfL -o %[2]s.SHA256SUM %[1]s.SHA256SUM
+	curl -sSfL -o %[2]s.SHA256SUM.sig %[1]s.SHA256SUM.sig
+fi
+
+export GNUPGHOME=${work}/gnupg
+mkdir -p -m 700 ${GNUPGHOME}
+gpg --batch --quiet --import pgp_keys.asc
+if ! gpg --batch --status-fd 1 --verify %[2]s.SHA256SUM.sig %[2]s.SHA256SUM 2>/dev/null | grep -q "^\[GNUPG:\] VALIDSIG .* %[5]s$"; then
+	echo "ERROR: the signature of %[2]s.SHA256SUM is not valid or not made by the Codecov key %[5]s" >&2
+	exit 1
+fi
+if ! sha256sum -c %[2]s.SHA256SUM; then
+	echo "ERROR: the checksum of %[2]s does not match the signed %[2]s.SHA256SUM" >&2
+	exit 1
+fi
+
+if [ -d /cache ]; then
+	mkdir -p ${cache}
+	cp %[2]s %[2]s.SHA256SUM %[2]s.SHA256SUM.sig pgp_keys.asc ${cache}/
+fi
+
+install -m 0755 %[2]s %[6]s
+rm -rf ${work}
+`, url, name, version, codecovPgpKeyUrl, codecovPgpKeyFingerprint, dst)
+
+	// Latest version can change, so we can't cache it.
+	// The legacy uploader and codecov-cli share the binary name, so the cache is keyed by the download host too
+	if version != "latest" {
+		host := strings.TrimPrefix(strings.TrimPrefix(baseUrl, "https://"), "http://")
+		ctr = ctr.WithMountedCache("/cache", dag.CacheVolume(fmt.Sprintf("codecov-%s-%s-%s", cacheKeyInvalidChars.ReplaceAllString(host, "_"), name, version)))
+	}
+
+	return ctr.
+		WithExec([]string{"sh", "-c", script}).
+		WithoutMount("/cache")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"golang.org/x/mod/modfile"
+)
+
+// AffectedPaths return the roots affected by the changes between baseRef and headRef.
+// A changed file affect every root that contains it, like a Dagger module and its Go module source. A root is also affected when
+// one of its local dependencies is affected: go.mod replace with local path and dagger.json dependencies with local source.
+// The source directory need the history of both refs
+func (m *Git) AffectedPaths(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+
+	// The base ref, like origin/main
+	baseRef string,
+
+	// The head ref
+	// +optional
+	// +default="HEAD"
+	headRef string,
+
+	// The roots to check, relative to the source directory
+	// Default to discover the directories that contain one of the marker files
+	// +optional
+	roots []string,
+
+	// The files that mark a root directory, used when roots is not set
+	// +optional
+	// +default=["dagger.json", "go.mod"]
+	markers []string,
+) ([]string, error) {
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+	if len(markers) == 0 {
+		markers = []string{"dagger.json", "go.mod"}
+	}
+
+	var err error
+	if len(roots) == 0 {
+		if roots, err = discoverRoots(ctx, src, markers); err != nil {
+			return nil, err
+		}
+	} else {
+		// Don't modify the caller roots
+		cleanRoots := make([]string, 0, len(roots))
+		for _, root := range roots {
+			cleanRoots = append(cleanRoots, path.Clean(root))
+		}
+		roots = cleanRoots
+	}
+
+	files, err := changedFiles(ctx, m.BaseContainer.WithDirectory(".", src), baseRef, headRef)
+	if err != nil {
+		return nil, err
+	}
+
+	dependents, err := reverseDependencies(ctx, src, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	return affectedRoots(roots, files, dependents), nil
+}
+
+// affectedRoots return the roots that contain one of the files, and the roots that depend on them
+func affectedRoots(roots []string, files []string, dependents map[string][]string) []string {
+	// Roots directly affected by changed files
+	affected := make(map[string]bool)
+	queue := make([]string, 0)
+	for _, file := range files {
+		for _, root := range ownerRoots(roots, file) {
+			if !affected[root] {
+				affected[root] = true
+				queue = append(queue, root)
+			}
+		}
+	}
+
+	// Roots affected by dependencies
+	for len(queue) > 0 {
+		root := queue[0]
+		queue = queue[1:]
+		for _, dependent := range dependents[root] {
+			if !affected[dependent] {
+				affected[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(affected))
+	for root := range affected {
+		result = append(result, root)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// discoverRoots return the directories that contain one of the marker files
+func discoverRoots(ctx context.Context, src *dagger.Directory, markers []string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, marker := range markers {
+		for _, pattern := range []string{marker, "**/" + marker} {
+			files, err := src.Glob(ctx, pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error when search %s files", marker)
+			}
+			for _, file := range files {
+				found[path.Dir(file)] = true
+			}
+		}
+	}
+
+	roots := make([]string, 0, len(found))
+	for root := range found {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	return roots, nil
+}
+
+// ownerRoots return all the roots that contain the file
+func ownerRoots(roots []string, file string) []string {
+	owners := make([]string, 0)
+	for _, root := range roots {
+		if root == "." || strings.HasPrefix(file, root+"/") {
+			owners = append(owners, root)
+		}
+	}
+
+	return owners
+}
+
+// reverseDependencies return for each root the list of roots that depend on it
+func reverseDependencies(ctx context.Context, src *dagger.Directory, roots []string) (map[string][]string, error) {
+	isRoot := make(map[string]bool)
+	for _, root := range roots {
+		isRoot[root] = true
+	}
+
+	// A require is only local when it's replaced by a local path, else it's resolved by the proxy
+	goMods := make(map[string]*modfile.File)
+	for _, root := range roots {
+		content, err := src.File(path.Join(root, "go.mod")).Contents(ctx)
+		if err != nil {
+			continue
+		}
+		f, err := modfile.Parse(path.Join(root, "go.mod"), []byte(content), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when parse %s/go.mod", root)
+		}
+		goMods[root] = f
+	}
+
+	dependents := make(map[string][]string)
+	addDependency := func(root string, dependency string) {
+		dependency = path.Clean(dependency)
+		if dependency == root || !isRoot[dependency] {
+			return
+		}
+		dependents[dependency] = append(dependents[dependency], root)
+	}
+
+	for _, root := range roots {
+		if f, ok := goMods[root]; ok {
+			for _, replace := range f.Replace {
+				if modfile.IsDirectoryPath(replace.New.Path) {
+					addDependency(root, path.Join(root, replace.New.Path))
+				}
+			}
+		}
+
+		content, err := src.File(path.Join(root, "dagger.json")).Contents(ctx)
+		if err != nil {
+			continue
+		}
+		daggerConfig := &struct {
+			Dependencies []struct {
+				Source string `json:"source"`
+			} `json:"dependencies"`
+		}{}
+		if err = json.Unmarshal([]byte(content), daggerConfig); err != nil {
+			return nil, errors.Wrapf(err, "Error when parse %s/dagger.json", root)
+		}
+		for _, dependency := range daggerConfig.Dependencies {
+			if strings.HasPrefix(dependency.Source, ".") {
+				addDependency(root, path.Join(root, dependency.Source))
+			}
+		}
+	}
+
+	return dependents, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAffectedRoots(t *testing.T) {
+	// test is a Dagger module (dagger.json) with its Go module source on test/test (go.mod)
+	roots := []string{"git", "helm", "test", "test/test"}
+	dependents := map[string][]string{
+		"git":  {"helm", "test"},
+		"helm": {"test"},
+	}
+
+	tests := []struct {
+		name  string
+		roots []string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "nested go.mod mark the enclosing dagger module",
+			roots: roots,
+			files: []string{"test/test/main.go"},
+			want:  []string{"test", "test/test"},
+		},
+		{
+			name:  "dagger.json only mark the dagger module",
+			roots: roots,
+			files: []string{"test/dagger.json"},
+			want:  []string{"test"},
+		},
+		{
+			name:  "dependents are marked",
+			roots: roots,
+			files: []string{"git/main.go"},
+			want:  []string{"git", "helm", "test"},
+		},
+		{
+			name:  "root path mark every change",
+			roots: append([]string{"."}, roots...),
+			files: []string{"helm/main.go"},
+			want:  []string{".", "helm", "test"},
+		},
+		{
+			name:  "file outside roots",
+			roots: roots,
+			files: []string{"README.md", "gitignore/file"},
+			want:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := affectedRoots(test.roots, test.files, dependents); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("affectedRoots() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
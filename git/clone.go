@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+const (
+	cloneDir          = "/clone"
+	sshSocketPath     = "/tmp/ssh-agent.sock"
+	sshKnownHostsPath = "/tmp/known_hosts"
+)
+
+// Only full SHA-1 or SHA-256 commit, servers reject fetching abbreviated SHA
+// and short hex strings can be branch or tag names
+var commitShaRegexp = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Clone clone a remote repository and return it as directory, with the .git directory.
+// Use token for HTTPS authentication or sshAuthSocket for SSH authentication.
+// The credentials are never written on the container filesystem
+func (m *Git) Clone(
+	ctx context.Context,
+
+	// The repository URL
+	url string,
+
+	// The branch, tag or full commit SHA to checkout
+	// Default to the remote HEAD
+	// +optional
+	ref string,
+
+	// Create a shallow clone with the given number of commits
+	// +optional
+	depth int,
+
+	// The paths to checkout with sparse checkout
+	// +optional
+	sparsePaths []string,
+
+	// The token used for HTTPS authentication
+	// +optional
+	token *dagger.Secret,
+
+	// The username used with the token
+	// +optional
+	// +default="x-access-token"
+	username string,
+
+	// The SSH agent socket used for SSH authentication
+	// +optional
+	sshAuthSocket *dagger.Socket,
+
+	// The known_hosts file used for SSH authentication
+	// Default to accept the host key on first connection
+	// +optional
+	sshKnownHosts *dagger.File,
+) (*dagger.Directory, error) {
+	ctr, err := m.withRemoteAuth(m.BaseContainer, url, token, username, sshAuthSocket, sshKnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	isCommit := commitShaRegexp.MatchString(ref)
+	if !isCommit {
+		// Branch and tag can move, so we never want to reuse cached clone
+		ctr = ctr.WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	}
+
+	cmd := []string{"git", "clone"}
+	if depth > 0 {
+		cmd = append(cmd, "--depth", strconv.Itoa(depth))
+	}
+	if len(sparsePaths) > 0 {
+		cmd = append(cmd, "--filter=blob:none", "--sparse")
+	}
+	if isCommit {
+		cmd = append(cmd, "--no-checkout")
+	} else if ref != "" {
+		cmd = append(cmd, "--branch", ref)
+	}
+	cmd = append(cmd, "--", url, cloneDir)
+
+	ctr = ctr.
+		WithExec(cmd).
+		WithWorkdir(cloneDir)
+
+	if len(sparsePaths) > 0 {
+		ctr = ctr.WithExec(append([]string{"git", "sparse-checkout", "set", "--"}, sparsePaths...))
+	}
+
+	if isCommit {
+		fetch := []string{"git", "fetch"}
+		if depth > 0 {
+			fetch = append(fetch, "--depth", strconv.Itoa(depth))
+		}
+		ctr = ctr.
+			WithExec(append(fetch, "origin", ref)).
+			WithExec([]string{"git", "checkout", "--detach", ref})
+	}
+
+	if _, err := ctr.Sync(ctx); err != nil {
+		return nil, errors.Wrapf(err, "Error when clone %s", url)
+	}
+
+	return ctr.Directory(cloneDir), nil
+}
+
+// Fetch fetch the remote of an existing repository and return the updated directory.
+// When ref is set, the current branch is fast forwarded to the fetched ref
+func (m *Git) Fetch(
+	ctx context.Context,
+
+	// The repository directory, with the .git directory
+	src *dagger.Directory,
+
+	// The remote name
+	// +optional
+	// +default="origin"
+	remote string,
+
+	// The branch, tag or commit SHA to fetch
+	// Default to fetch all branches and tags
+	// +optional
+	ref string,
+
+	// Limit fetching to the given number of commits
+	// +optional
+	depth int,
+
+	// The token used for HTTPS authentication
+	// +optional
+	token *dagger.Secret,
+
+	// The username used with the token
+	// +optional
+	// +default="x-access-token"
+	username string,
+
+	// The SSH agent socket used for SSH authentication
+	// +optional
+	sshAuthSocket *dagger.Socket,
+
+	// The known_hosts file used for SSH authentication
+	// Default to accept the host key on first connection
+	// +optional
+	sshKnownHosts *dagger.File,
+) (*dagger.Directory, error) {
+	if remote == "" {
+		remote = "origin"
+	}
+
+	ctr := m.BaseContainer.WithDirectory(".", src)
+	remoteUrl, err := gitOutput(ctx, ctr, "remote", "get-url", remote)
+	if err != nil {
+		return nil, err
+	}
+	if ctr, err = m.withRemoteAuth(ctr, remoteUrl, token, username, sshAuthSocket, sshKnownHosts); err != nil {
+		return nil, err
+	}
+	ctr = ctr.WithEnvVariable("CACHE_BUSTER", time.Now().String())
+
+	cmd := []string{"git", "fetch", "--tags"}
+	if depth > 0 {
+		cmd = append(cmd, "--depth", strconv.Itoa(depth))
+	}
+	cmd = append(cmd, remote)
+	if ref != "" {
+		cmd = append(cmd, ref)
+	}
+	ctr = ctr.WithExec(cmd)
+
+	if ref != "" {
+		ctr = ctr.WithExec([]string{"git", "merge", "--ff-only", "FETCH_HEAD"})
+	}
+
+	if _, err := ctr.Sync(ctx); err != nil {
+		return nil, errors.Wrapf(err, "Error when fetch %s", remote)
+	}
+
+	return ctr.Directory("."), nil
+}
+
+// withRemoteAuth configure the container to authenticate on the remote URL
+// The token is handled by the credential helper, like with WithCredential, so it's never written on the container filesystem
+func (m *Git) withRemoteAuth(ctr *dagger.Container, remoteUrl string, token *dagger.Secret, username string, sshAuthSocket *dagger.Socket, knownHosts *dagger.File) (*dagger.Container, error) {
+	if token != nil {
+		u, err := url.Parse(remoteUrl)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, errors.Errorf("The token can only be used with HTTPS remote, not %s", stripCredentials(remoteUrl))
+		}
+		ctr = m.withHostCredential(ctr, u.Host, username, token)
+	}
+
+	if sshAuthSocket != nil {
+		sshCommand := "ssh -o StrictHostKeyChecking=accept-new"
+		if knownHosts != nil {
+			ctr = ctr.WithMountedFile(sshKnownHostsPath, knownHosts)
+			sshCommand = fmt.Sprintf("ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s", sshKnownHostsPath)
+		}
+		ctr = ctr.
+			WithUnixSocket(sshSocketPath, sshAuthSocket).
+			WithEnvVariable("SSH_AUTH_SOCK", sshSocketPath).
+			WithEnvVariable("GIT_SSH_COMMAND", sshCommand)
+	}
+
+	return ctr, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"dagger/git/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	credentialHelperPath = "/usr/local/bin/git-credential-dagger"
+	githubAppKeyDir      = "/run/secrets/git"
+)
+
+var credentialHostInvalidChars = regexp.MustCompile(`[^A-Z0-9]`)
+
+// credentialHelper is a git credential helper that read the credentials from environment variables.
+// The variables are suffixed by the host name, so we can handle multiple hosts.
+// When a GitHub App private key is provided, it compute an installation token on each call.
+const credentialHelper = `#!/bin/sh
+[ "$1" = "get" ] || exit 0
+
+host=""
+while IFS='=' read -r key value; do
+	[ -z "$key" ] && break
+	[ "$key" = "host" ] && host="$value"
+done
+
+suffix=$(echo "$host" | tr '[:lower:]' '[:upper:]' | sed 's/[^A-Z0-9]/_/g')
+eval username=\${GIT_USERNAME_${suffix}:-}
+eval token=\${GIT_TOKEN_${suffix}:-}
+eval app_key=\${GIT_APP_KEY_FILE_${suffix}:-}
+
+if [ -n "$app_key" ]; then
+	eval app_id=\${GIT_APP_ID_${suffix}}
+	eval installation_id=\${GIT_APP_INSTALLATION_ID_${suffix}}
+	eval api_url=\${GIT_APP_API_URL_${suffix}}
+
+	b64() { openssl base64 -A | tr '+/' '-_' | tr -d '='; }
+	now=$(date +%s)
+	header=$(printf '{"alg":"RS256","typ":"JWT"}' | b64)
+	payload=$(printf '{"iat":%s,"exp":%s,"iss":"%s"}' $((now - 60)) $((now + 540)) "$app_id" | b64)
+	signature=$(printf '%s.%s' "$header" "$payload" | openssl dgst -sha256 -sign "$app_key" | b64)
+	token=$(curl -sSf -X POST \
+		-H "Authorization: Bearer ${header}.${payload}.${signature}" \
+		-H "Accept: application/vnd.github+json" \
+		"${api_url}/app/installations/${installation_id}/access_tokens" | sed -n 's/.*"token": *"\([^"]*\)".*/\1/p')
+	username="x-access-token"
+fi
+
+[ -n "$token" ] || exit 0
+echo "username=${username:-x-access-token}"
+echo "password=${token}"
+`
+
+// WithCredential add a token to authenticate on a git host over HTTPS (GitHub, GitLab, Gitea...)
+// The token stay a secret: it's only exposed as secret environment variable read by a credential helper.
+// Each call will add credentials for a new host
+func (m *Git) WithCredential(
+	// The git host, like github.com
+	host string,
+
+	// The username used with the token
+	// +optional
+	// +default="x-access-token"
+	username string,
+
+	// The token
+	token *dagger.Secret,
+) *Git {
+	m.BaseContainer = m.withHostCredential(m.BaseContainer, host, username, token)
+
+	return m
+}
+
+// WithGithubAppCredential authenticate on GitHub with a GitHub App installation token.
+// The installation token is computed on demand from the App private key, that is only mounted as secret.
+// It need openssl and curl on the base container
+func (m *Git) WithGithubAppCredential(
+	// The GitHub App ID
+	appId string,
+
+	// The installation ID of the GitHub App on the organization or repository
+	installationId string,
+
+	// The GitHub App private key, on PEM format
+	privateKey *dagger.Secret,
+
+	// The GitHub host
+	// +optional
+	// +default="github.com"
+	host string,
+
+	// The GitHub API URL
+	// +optional
+	// +default="https://api.github.com"
+	apiUrl string,
+) *Git {
+	if host == "" {
+		host = "github.com"
+	}
+	if apiUrl == "" {
+		apiUrl = "https://api.github.com"
+	}
+
+	suffix := credentialHostSuffix(host)
+	keyPath := fmt.Sprintf("%s/%s.pem", githubAppKeyDir, strings.ToLower(suffix))
+	m.BaseContainer = m.withCredentialHelper(m.BaseContainer).
+		WithMountedSecret(keyPath, privateKey).
+		WithEnvVariable(fmt.Sprintf("GIT_APP_KEY_FILE_%s", suffix), keyPath).
+		WithEnvVariable(fmt.Sprintf("GIT_APP_ID_%s", suffix), appId).
+		WithEnvVariable(fmt.Sprintf("GIT_APP_INSTALLATION_ID_%s", suffix), installationId).
+		WithEnvVariable(fmt.Sprintf("GIT_APP_API_URL_%s", suffix), strings.TrimSuffix(apiUrl, "/"))
+
+	return m
+}
+
+// withHostCredential add the token of the git host on the container, read by the credential helper
+func (m *Git) withHostCredential(ctr *dagger.Container, host string, username string, token *dagger.Secret) *dagger.Container {
+	if username == "" {
+		username = "x-access-token"
+	}
+
+	suffix := credentialHostSuffix(host)
+	return m.withCredentialHelper(ctr).
+		WithEnvVariable(fmt.Sprintf("GIT_USERNAME_%s", suffix), username).
+		WithSecretVariable(fmt.Sprintf("GIT_TOKEN_%s", suffix), token)
+}
+
+// withCredentialHelper install the credential helper and configure git to use it
+func (m *Git) withCredentialHelper(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithNewFile(credentialHelperPath, credentialHelper, dagger.ContainerWithNewFileOpts{Permissions: 0755}).
+		WithExec([]string{"git", "config", "--global", "--replace-all", "credential.helper", "dagger"})
+}
+
+// credentialHostSuffix return the environment variable suffix of a git host
+func credentialHostSuffix(host string) string {
+	return credentialHostInvalidChars.ReplaceAllString(strings.ToUpper(host), "_")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"fmt"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+const (
+	diffDir   = "/diff"
+	afterDir  = "/tmp/after"
+	patchPath = "/tmp/changes.patch"
+)
+
+// DirectoryDiff is the result of Diff
+type DirectoryDiff struct {
+	// The unified patch, with binary changes
+	Patch *dagger.File
+
+	// The changed paths
+	Paths []string
+}
+
+// Diff compare two directories and return the unified patch and the changed paths.
+// The .git directories are ignored
+func (m *Git) Diff(
+	ctx context.Context,
+
+	// The original directory
+	before *dagger.Directory,
+
+	// The modified directory
+	after *dagger.Directory,
+) (*DirectoryDiff, error) {
+	ctr := m.diffContainer(before, after)
+
+	stdout, err := ctr.
+		WithExec([]string{"git", "diff", "--cached", "--name-only", "-z"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when compare directories")
+	}
+
+	paths := make([]string, 0)
+	for _, path := range strings.Split(stdout, "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return &DirectoryDiff{
+		Patch: ctr.
+			WithExec([]string{"git", "diff", "--cached", "--binary", "--no-color", "--output", patchPath}).
+			File(patchPath),
+		Paths: paths,
+	}, nil
+}
+
+// CheckDrift fail with a readable diff when the directories are not the same.
+// It's usefull to check that generated files are up to date
+func (m *Git) CheckDrift(
+	ctx context.Context,
+
+	// The original directory
+	before *dagger.Directory,
+
+	// The directory after generation
+	after *dagger.Directory,
+) (string, error) {
+	diff, err := m.Diff(ctx, before, after)
+	if err != nil {
+		return "", err
+	}
+	if len(diff.Paths) == 0 {
+		return "No drift detected", nil
+	}
+
+	patch, err := diff.Patch.Contents(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "Error when read patch")
+	}
+
+	return "", errors.Errorf("Drift detected on %d files:\n%s", len(diff.Paths), patch)
+}
+
+// Apply apply a patch produced by Diff or git diff on a directory, and return the updated directory
+func (m *Git) Apply(
+	ctx context.Context,
+
+	// The directory to patch
+	dir *dagger.Directory,
+
+	// The patch file
+	patch *dagger.File,
+) (*dagger.Directory, error) {
+	ctr := m.BaseContainer.
+		WithDirectory(".", dir).
+		WithMountedFile(patchPath, patch).
+		WithExec([]string{"git", "apply", "--binary", "--whitespace=nowarn", patchPath})
+
+	if _, err := ctr.Sync(ctx); err != nil {
+		return nil, errors.Wrap(err, "Error when apply patch")
+	}
+
+	return ctr.Directory("."), nil
+}
+
+// diffContainer return a container with a temporary repository where the before directory
+// is committed and the after directory is staged
+func (m *Git) diffContainer(before *dagger.Directory, after *dagger.Directory) *dagger.Container {
+	excludeGit := dagger.ContainerWithDirectoryOpts{Exclude: []string{".git", "**/.git"}}
+
+	return m.BaseContainer.
+		WithDirectory(diffDir, before, excludeGit).
+		WithDirectory(afterDir, after, excludeGit).
+		WithWorkdir(diffDir).
+		WithExec([]string{"git", "init", "-q"}).
+		WithExec([]string{"git", "add", "-A"}).
+		WithExec([]string{"git", "-c", "user.name=dagger", "-c", "user.email=dagger@localhost", "-c", "commit.gpgsign=false", "commit", "-q", "--allow-empty", "-m", "before"}).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("find . -mindepth 1 -maxdepth 1 ! -name .git -exec rm -rf {} + && cp -a %s/. .", afterDir)}).
+		WithExec([]string{"git", "add", "-A"})
+}
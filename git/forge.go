@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+const (
+	forgeBodyPath = "/tmp/forge-body.json"
+	giteaPageSize = 50
+)
+
+// pullRequestSpec is the expected state of a pull request
+type pullRequestSpec struct {
+	Branch    string
+	Base      string
+	Title     string
+	Body      string
+	Labels    []string
+	Reviewers []string
+}
+
+// forge is a git hosting API client
+type forge interface {
+	// FindPullRequest return the open pull request of the branch, or nil
+	FindPullRequest(ctx context.Context, branch string) (*PullRequest, error)
+
+	// CreatePullRequest open a new pull request
+	CreatePullRequest(ctx context.Context, spec *pullRequestSpec) (*PullRequest, error)
+
+	// UpdatePullRequest update the title, body, labels and reviewers of a pull request
+	UpdatePullRequest(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error)
+}
+
+// newForge return the API client of the provider
+func newForge(ctr *dagger.Container, provider string, apiUrl string, repository string, token *dagger.Secret) (forge, error) {
+	client := &forgeClient{
+		Container:  ctr.WithSecretVariable("FORGE_TOKEN", token),
+		Repository: repository,
+	}
+
+	switch provider {
+	case "github":
+		if apiUrl == "" {
+			apiUrl = "https://api.github.com"
+		}
+		client.ApiUrl = strings.TrimSuffix(apiUrl, "/")
+		client.AuthHeader = "Authorization: Bearer "
+		return &githubForge{client}, nil
+	case "gitlab":
+		if apiUrl == "" {
+			apiUrl = "https://gitlab.com/api/v4"
+		}
+		client.ApiUrl = strings.TrimSuffix(apiUrl, "/")
+		client.AuthHeader = "PRIVATE-TOKEN: "
+		return &gitlabForge{client}, nil
+	case "gitea":
+		if apiUrl == "" {
+			return nil, errors.New("The apiUrl is required with gitea provider")
+		}
+		client.ApiUrl = strings.TrimSuffix(apiUrl, "/")
+		client.AuthHeader = "Authorization: token "
+		return &giteaForge{client}, nil
+	default:
+		return nil, errors.Errorf("Provider %s not supported, it need to be github, gitlab or gitea", provider)
+	}
+}
+
+// forgeClient call the forge API with curl, so the token stay a secret
+type forgeClient struct {
+	Container  *dagger.Container
+	ApiUrl     string
+	AuthHeader string
+	Repository string
+}
+
+// call run the API request and decode the JSON response on result
+func (h *forgeClient) call(ctx context.Context, method string, path string, body any, result any) error {
+	ctr := h.Container.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+
+	cmd := []string{
+		"sh", "-c",
+		`curl -sS --fail-with-body -X "$1" -H "Accept: application/json" -H "Content-Type: application/json" -H "$2${FORGE_TOKEN}" ${4:+--data-binary @$4} "$3"`,
+		"forge-api", method, h.AuthHeader, h.ApiUrl + path, "",
+	}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "Error when encode request body")
+		}
+		ctr = ctr.WithNewFile(forgeBodyPath, string(data))
+		cmd[len(cmd)-1] = forgeBodyPath
+	}
+
+	stdout, err := ctr.WithExec(cmd).Stdout(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "Error when call %s %s", method, path)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err = json.Unmarshal([]byte(stdout), result); err != nil {
+		return errors.Wrapf(err, "Error when decode response of %s %s", method, path)
+	}
+
+	return nil
+}
+
+type githubForge struct {
+	*forgeClient
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	HtmlUrl string `json:"html_url"`
+}
+
+func (h *githubForge) FindPullRequest(ctx context.Context, branch string) (*PullRequest, error) {
+	owner := strings.SplitN(h.Repository, "/", 2)[0]
+	query := url.Values{"state": {"open"}, "head": {fmt.Sprintf("%s:%s", owner, branch)}}
+
+	prs := make([]githubPullRequest, 0)
+	if err := h.call(ctx, "GET", fmt.Sprintf("/repos/%s/pulls?%s", h.Repository, query.Encode()), nil, &prs); err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+
+	return &PullRequest{Number: prs[0].Number, Url: prs[0].HtmlUrl}, nil
+}
+
+func (h *githubForge) CreatePullRequest(ctx context.Context, spec *pullRequestSpec) (*PullRequest, error) {
+	pr := &githubPullRequest{}
+	body := map[string]any{"title": spec.Title, "body": spec.Body, "head": spec.Branch, "base": spec.Base}
+	if err := h.call(ctx, "POST", fmt.Sprintf("/repos/%s/pulls", h.Repository), body, pr); err != nil {
+		return nil, err
+	}
+
+	return h.setMetadata(ctx, &PullRequest{Number: pr.Number, Url: pr.HtmlUrl, Created: true}, spec)
+}
+
+func (h *githubForge) UpdatePullRequest(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error) {
+	body := map[string]any{"title": spec.Title, "body": spec.Body}
+	if err := h.call(ctx, "PATCH", fmt.Sprintf("/repos/%s/pulls/%d", h.Repository, pr.Number), body, nil); err != nil {
+		return nil, err
+	}
+
+	return h.setMetadata(ctx, pr, spec)
+}
+
+func (h *githubForge) setMetadata(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error) {
+	if len(spec.Labels) > 0 {
+		body := map[string]any{"labels": spec.Labels}
+		if err := h.call(ctx, "PUT", fmt.Sprintf("/repos/%s/issues/%d/labels", h.Repository, pr.Number), body, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(spec.Reviewers) > 0 {
+		body := map[string]any{"reviewers": spec.Reviewers}
+		if err := h.call(ctx, "POST", fmt.Sprintf("/repos/%s/pulls/%d/requested_reviewers", h.Repository, pr.Number), body, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return pr, nil
+}
+
+type gitlabForge struct {
+	*forgeClient
+}
+
+type gitlabMergeRequest struct {
+	Iid    int    `json:"iid"`
+	WebUrl string `json:"web_url"`
+}
+
+func (h *gitlabForge) project() string {
+	return url.PathEscape(h.Repository)
+}
+
+func (h *gitlabForge) FindPullRequest(ctx context.Context, branch string) (*PullRequest, error) {
+	query := url.Values{"state": {"opened"}, "source_branch": {branch}}
+
+	mrs := make([]gitlabMergeRequest, 0)
+	if err := h.call(ctx, "GET", fmt.Sprintf("/projects/%s/merge_requests?%s", h.project(), query.Encode()), nil, &mrs); err != nil {
+		return nil, err
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+
+	return &PullRequest{Number: mrs[0].Iid, Url: mrs[0].WebUrl}, nil
+}
+
+func (h *gitlabForge) CreatePullRequest(ctx context.Context, spec *pullRequestSpec) (*PullRequest, error) {
+	body, err := h.body(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	body["source_branch"] = spec.Branch
+	body["target_branch"] = spec.Base
+
+	mr := &gitlabMergeRequest{}
+	if err = h.call(ctx, "POST", fmt.Sprintf("/projects/%s/merge_requests", h.project()), body, mr); err != nil {
+		return nil, err
+	}
+
+	return &PullRequest{Number: mr.Iid, Url: mr.WebUrl, Created: true}, nil
+}
+
+func (h *gitlabForge) UpdatePullRequest(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error) {
+	body, err := h.body(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.call(ctx, "PUT", fmt.Sprintf("/projects/%s/merge_requests/%d", h.project(), pr.Number), body, nil); err != nil {
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// body return the merge request fields. GitLab expect reviewer IDs, so we need to resolve the usernames
+func (h *gitlabForge) body(ctx context.Context, spec *pullRequestSpec) (map[string]any, error) {
+	body := map[string]any{"title": spec.Title, "description": spec.Body}
+	if len(spec.Labels) > 0 {
+		body["labels"] = strings.Join(spec.Labels, ",")
+	}
+
+	if len(spec.Reviewers) > 0 {
+		reviewerIds := make([]int, 0, len(spec.Reviewers))
+		for _, reviewer := range spec.Reviewers {
+			users := make([]struct {
+				Id int `json:"id"`
+			}, 0)
+			if err := h.call(ctx, "GET", fmt.Sprintf("/users?%s", url.Values{"username": {reviewer}}.Encode()), nil, &users); err != nil {
+				return nil, err
+			}
+			if len(users) == 0 {
+				return nil, errors.Errorf("Reviewer %s not found", reviewer)
+			}
+			reviewerIds = append(reviewerIds, users[0].Id)
+		}
+		body["reviewer_ids"] = reviewerIds
+	}
+
+	return body, nil
+}
+
+type giteaForge struct {
+	*forgeClient
+}
+
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	HtmlUrl string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+type giteaLabel struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (h *giteaForge) FindPullRequest(ctx context.Context, branch string) (*PullRequest, error) {
+	prs, err := giteaList[giteaPullRequest](ctx, h, fmt.Sprintf("/repos/%s/pulls", h.Repository), url.Values{"state": {"open"}})
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range prs {
+		if pr.Head.Ref == branch {
+			return &PullRequest{Number: pr.Number, Url: pr.HtmlUrl}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (h *giteaForge) CreatePullRequest(ctx context.Context, spec *pullRequestSpec) (*PullRequest, error) {
+	pr := &giteaPullRequest{}
+	body := map[string]any{"title": spec.Title, "body": spec.Body, "head": spec.Branch, "base": spec.Base}
+	if err := h.call(ctx, "POST", fmt.Sprintf("/repos/%s/pulls", h.Repository), body, pr); err != nil {
+		return nil, err
+	}
+
+	return h.setMetadata(ctx, &PullRequest{Number: pr.Number, Url: pr.HtmlUrl, Created: true}, spec)
+}
+
+func (h *giteaForge) UpdatePullRequest(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error) {
+	body := map[string]any{"title": spec.Title, "body": spec.Body}
+	if err := h.call(ctx, "PATCH", fmt.Sprintf("/repos/%s/pulls/%d", h.Repository, pr.Number), body, nil); err != nil {
+		return nil, err
+	}
+
+	return h.setMetadata(ctx, pr, spec)
+}
+
+// setMetadata set labels and reviewers. Gitea expect label IDs, so we need to resolve the label names
+func (h *giteaForge) setMetadata(ctx context.Context, pr *PullRequest, spec *pullRequestSpec) (*PullRequest, error) {
+	if len(spec.Labels) > 0 {
+		labels, err := giteaList[giteaLabel](ctx, h, fmt.Sprintf("/repos/%s/labels", h.Repository), url.Values{})
+		if err != nil {
+			return nil, err
+		}
+		labelIds := make([]int, 0, len(spec.Labels))
+		for _, name := range spec.Labels {
+			found := false
+			for _, label := range labels {
+				if label.Name == name {
+					labelIds = append(labelIds, label.Id)
+					found = true
+				}
+			}
+			if !found {
+				return nil, errors.Errorf("Label %s not found", name)
+			}
+		}
+		if err := h.call(ctx, "PUT", fmt.Sprintf("/repos/%s/issues/%d/labels", h.Repository, pr.Number), map[string]any{"labels": labelIds}, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(spec.Reviewers) > 0 {
+		body := map[string]any{"reviewers": spec.Reviewers}
+		if err := h.call(ctx, "POST", fmt.Sprintf("/repos/%s/pulls/%d/requested_reviewers", h.Repository, pr.Number), body, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return pr, nil
+}
+
+// giteaList call the list API page by page, until an empty page
+// The page size can be lowered by the server, so we can't stop on the first incomplete page
+func giteaList[T any](ctx context.Context, h *giteaForge, path string, query url.Values) ([]T, error) {
+	items := make([]T, 0)
+	query.Set("limit", strconv.Itoa(giteaPageSize))
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		pageItems := make([]T, 0)
+		if err := h.call(ctx, "GET", fmt.Sprintf("%s?%s", path, query.Encode()), nil, &pageItems); err != nil {
+			return nil, err
+		}
+		if len(pageItems) == 0 {
+			return items, nil
+		}
+		items = append(items, pageItems...)
+	}
+}
@@ -8,6 +8,7 @@ require (
 	emperror.dev/errors v0.8.1
 	github.com/99designs/gqlgen v0.17.63
 	github.com/Khan/genqlient v0.7.0
+	github.com/coreos/go-semver v0.3.1
 	github.com/disaster37/dagger-library-go/lib v0.0.0-20250227103511-2533a680ecf2
 	github.com/vektah/gqlparser/v2 v2.5.21
 	go.opentelemetry.io/otel v1.32.0
@@ -24,6 +25,7 @@ require (
 	go.opentelemetry.io/otel/sdk/metric v1.32.0
 	go.opentelemetry.io/otel/trace v1.32.0
 	go.opentelemetry.io/proto/otlp v1.3.1
+	golang.org/x/mod v0.23.0
 	golang.org/x/sync v0.11.0
 	google.golang.org/grpc v1.68.0
 )
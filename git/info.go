@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// RepositoryInfo is the metadata of the current commit of a repository
+type RepositoryInfo struct {
+	// The current branch name, HEAD when detached
+	Branch string
+
+	// The full commit SHA
+	Sha string
+
+	// The short commit SHA
+	ShortSha string
+
+	// The git describe output, like v1.2.0-3-gabcdef0
+	Describe string
+
+	// The nearest tag, empty when there are no tag
+	Tag string
+
+	// True if there are uncommitted changes
+	Dirty bool
+
+	// The commit author name
+	Author string
+
+	// The commit author email
+	AuthorEmail string
+
+	// The commit timestamp, in seconds since epoch
+	CommitTimestamp int
+
+	// The origin remote URL, without credentials
+	RemoteUrl string
+}
+
+// Info return the metadata of the current commit
+func (m *Git) Info(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+) (*RepositoryInfo, error) {
+	ctr := m.BaseContainer.WithDirectory(".", src)
+
+	var err error
+	info := &RepositoryInfo{}
+
+	if info.Branch, err = gitOutput(ctx, ctr, "rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+		return nil, err
+	}
+	if info.Sha, err = gitOutput(ctx, ctr, "rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	if info.ShortSha, err = gitOutput(ctx, ctr, "rev-parse", "--short", "HEAD"); err != nil {
+		return nil, err
+	}
+	if info.Describe, err = gitOutput(ctx, ctr, "describe", "--tags", "--always"); err != nil {
+		return nil, err
+	}
+
+	status, err := gitOutput(ctx, ctr, "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	info.Dirty = status != ""
+
+	commit, err := gitOutput(ctx, ctr, "log", "-1", "--format=%an%x1f%ae%x1f%ct")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(commit, logFieldSeparator)
+	if len(fields) == 3 {
+		info.Author = fields[0]
+		info.AuthorEmail = fields[1]
+		if info.CommitTimestamp, err = strconv.Atoi(fields[2]); err != nil {
+			return nil, errors.Wrapf(err, "Error when decode commit timestamp %s", fields[2])
+		}
+	}
+
+	// The repository can have no tag and no remote
+	info.Tag, _ = gitOutput(ctx, ctr, "describe", "--tags", "--abbrev=0")
+	remoteUrl, _ := gitOutput(ctx, ctr, "remote", "get-url", "origin")
+	info.RemoteUrl = stripCredentials(remoteUrl)
+
+	return info, nil
+}
+
+// ChangedFiles return the files changed between the merge base of baseRef and headRef, and headRef
+// The source directory need the history of both refs.
+// With uncommitted, it also return the uncommitted and untracked files of the working tree
+func (m *Git) ChangedFiles(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+
+	// The base ref, like origin/main
+	baseRef string,
+
+	// The head ref
+	// +optional
+	// +default="HEAD"
+	headRef string,
+
+	// Set true to compare the merge base with the working tree, including the uncommitted and untracked files.
+	// The headRef need to be HEAD
+	// +optional
+	uncommitted bool,
+) ([]string, error) {
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+
+	ctr := m.BaseContainer.WithDirectory(".", src)
+	if !uncommitted {
+		return changedFiles(ctx, ctr, baseRef, headRef)
+	}
+
+	if headRef != "HEAD" {
+		return nil, errors.Errorf("The headRef need to be HEAD with uncommitted, got %s", headRef)
+	}
+	base, err := gitOutput(ctx, ctr, "merge-base", baseRef, headRef)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := gitOutput(ctx, ctr, "diff", "--name-only", "-z", base)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitOutput(ctx, ctr, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitFiles(stdout + "\x00" + untracked), nil
+}
+
+// changedFiles return the files changed between the merge base of baseRef and headRef, and headRef
+func changedFiles(ctx context.Context, ctr *dagger.Container, baseRef string, headRef string) ([]string, error) {
+	stdout, err := gitOutput(ctx, ctr, "diff", "--name-only", "-z", baseRef+"..."+headRef)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitFiles(stdout), nil
+}
+
+// splitFiles split the NUL separated output of git
+func splitFiles(stdout string) []string {
+	files := make([]string, 0)
+	for _, file := range strings.Split(stdout, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// gitOutput run a git command and return its trimmed output
+func gitOutput(ctx context.Context, ctr *dagger.Container, args ...string) (string, error) {
+	stdout, err := ctr.
+		WithExec(append([]string{"git"}, args...)).
+		Stdout(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error when run git %s", strings.Join(args, " "))
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// stripCredentials remove the user info from remote URL
+func stripCredentials(remoteUrl string) string {
+	u, err := url.Parse(remoteUrl)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return remoteUrl
+	}
+	if u.Scheme == "ssh" {
+		return remoteUrl
+	}
+	u.User = nil
+
+	return u.String()
+}
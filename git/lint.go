@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"fmt"
+	"slices"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+var defaultCommitTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// CommitLintReport is the result of LintCommits
+type CommitLintReport struct {
+	// True if all commits are valid
+	Valid bool
+
+	// The report of each commit, newest first
+	Commits []*CommitLint
+}
+
+// CommitLint is the lint result of one commit
+type CommitLint struct {
+	// The commit SHA
+	Sha string
+
+	// The commit subject
+	Subject string
+
+	// The commit author email
+	AuthorEmail string
+
+	// True if the commit is valid
+	Valid bool
+
+	// The rules violated by the commit
+	Errors []string
+}
+
+// LintCommits check the commits between baseRef and headRef against conventional commits rules
+// and optionally the DCO sign-off. The source directory need the history of both refs
+func (m *Git) LintCommits(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+
+	// The base ref, like origin/main. Its commits are not checked
+	baseRef string,
+
+	// The head ref
+	// +optional
+	// +default="HEAD"
+	headRef string,
+
+	// The allowed commit types
+	// +optional
+	// +default=["feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"]
+	types []string,
+
+	// The allowed scopes. Default to allow any scope
+	// +optional
+	scopes []string,
+
+	// Set true to require a scope
+	// +optional
+	requireScope bool,
+
+	// The maximum length of the commit header
+	// +optional
+	// +default=100
+	maxHeaderLength int,
+
+	// Set true to require a Signed-off-by trailer matching the author email (DCO)
+	// +optional
+	signOff bool,
+
+	// Set true to allow merge commits
+	// +optional
+	allowMerge bool,
+
+	// Set true to allow fixup! and squash! commits
+	// +optional
+	allowFixup bool,
+) (*CommitLintReport, error) {
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+	if len(types) == 0 {
+		types = defaultCommitTypes
+	}
+
+	stdout, err := m.BaseContainer.
+		WithDirectory(".", src).
+		WithExec([]string{"git", "log", "--format=%H%x1f%P%x1f%ae%x1f%s%x1f%b%x1e", fmt.Sprintf("%s..%s", baseRef, headRef)}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when read git log %s..%s", baseRef, headRef)
+	}
+
+	report := &CommitLintReport{
+		Valid:   true,
+		Commits: make([]*CommitLint, 0),
+	}
+
+	for _, entry := range strings.Split(stdout, logCommitSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.SplitN(entry, logFieldSeparator, 5)
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		c := parseCommit(fields[0], fields[3], strings.TrimSpace(fields[4]))
+		isMerge := len(strings.Fields(fields[1])) > 1
+
+		result := &CommitLint{
+			Sha:         c.Hash,
+			Subject:     c.Subject,
+			AuthorEmail: fields[2],
+			Errors:      make([]string, 0),
+		}
+
+		switch {
+		case isMerge:
+			if !allowMerge {
+				result.Errors = append(result.Errors, "merge commits are not allowed")
+			}
+		case isFixup(c.Subject):
+			if !allowFixup {
+				result.Errors = append(result.Errors, "fixup and squash commits are not allowed")
+			}
+		default:
+			result.Errors = append(result.Errors, lintConventionalCommit(c, types, scopes, requireScope, maxHeaderLength)...)
+		}
+
+		if signOff && !isSignedOff(c.Body, result.AuthorEmail) {
+			result.Errors = append(result.Errors, fmt.Sprintf("missing Signed-off-by trailer for %s", result.AuthorEmail))
+		}
+
+		result.Valid = len(result.Errors) == 0
+		if !result.Valid {
+			report.Valid = false
+		}
+		report.Commits = append(report.Commits, result)
+	}
+
+	return report, nil
+}
+
+// lintConventionalCommit return the conventional commits rules violated by the commit
+func lintConventionalCommit(c *commit, types []string, scopes []string, requireScope bool, maxHeaderLength int) []string {
+	errs := make([]string, 0)
+
+	if maxHeaderLength > 0 && len(c.Subject) > maxHeaderLength {
+		errs = append(errs, fmt.Sprintf("header is longer than %d characters", maxHeaderLength))
+	}
+
+	if c.Type == "" {
+		return append(errs, "header does not follow the format type(scope): subject")
+	}
+
+	if !slices.Contains(types, c.Type) {
+		errs = append(errs, fmt.Sprintf("type %s is not allowed, it need to be one of %s", c.Type, strings.Join(types, ", ")))
+	}
+
+	if c.Scope == "" && requireScope {
+		errs = append(errs, "scope is required")
+	}
+	if c.Scope != "" && len(scopes) > 0 && !slices.Contains(scopes, c.Scope) {
+		errs = append(errs, fmt.Sprintf("scope %s is not allowed, it need to be one of %s", c.Scope, strings.Join(scopes, ", ")))
+	}
+
+	return errs
+}
+
+// isFixup return true if the commit is created with git commit --fixup or --squash
+func isFixup(subject string) bool {
+	return strings.HasPrefix(subject, "fixup!") || strings.HasPrefix(subject, "squash!") || strings.HasPrefix(subject, "amend!")
+}
+
+// isSignedOff return true if the body contain a Signed-off-by trailer with the email
+func isSignedOff(body string, email string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToLower(line), "signed-off-by:") && strings.Contains(strings.ToLower(line), "<"+strings.ToLower(email)+">") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -16,9 +16,10 @@ package main
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"dagger/git/internal/dagger"
-	"fmt"
 
 	"emperror.dev/errors"
 	"github.com/disaster37/dagger-library-go/lib/helper"
@@ -42,7 +43,7 @@ func New(
 	} else {
 		git.BaseContainer = dag.Container().
 			From("alpine:latest").
-			WithExec(helper.ForgeCommand("apk add --update git")).
+			WithExec(helper.ForgeCommand("apk add --update git openssh-client openssh-keygen openssl curl gnupg")).
 			WithWorkdir("/project")
 	}
 
@@ -53,16 +54,14 @@ func New(
 	return git
 }
 
+// GetCurrentBranchName return the current branch name
 func (m *Git) GetCurrentBranchName(
 	ctx context.Context,
 
 	// The source directory
 	src *dagger.Directory,
 ) (string, error) {
-	return m.BaseContainer.
-		WithDirectory(".", src).
-		WithExec(helper.ForgeCommand("git rev-parse --abbrev-ref HEAD")).
-		Stdout(ctx)
+	return gitOutput(ctx, m.BaseContainer.WithDirectory(".", src), "rev-parse", "--abbrev-ref", "HEAD")
 }
 
 func (m *Git) WithCustomContainer(c *dagger.Container) *Git {
@@ -71,6 +70,7 @@ func (m *Git) WithCustomContainer(c *dagger.Container) *Git {
 }
 
 // SetConfig permit to set git config
+// The token is kept as secret, see WithCredential
 func (m *Git) SetConfig(
 	ctx context.Context,
 
@@ -94,13 +94,10 @@ func (m *Git) SetConfig(
 		WithExec(helper.ForgeCommandf("git config --global user.email %s", email))
 
 	if token != nil {
-		tokenPlain, err := token.Plaintext(ctx)
-		if err != nil {
-			return nil, errors.Wrap(err, "Error when get token")
+		if baseRepoUrl == "" {
+			baseRepoUrl = "github.com"
 		}
-		m.BaseContainer = m.BaseContainer.
-			WithNewFile("/.git-credentials", fmt.Sprintf(`https://%s:%s@%s`, username, tokenPlain, baseRepoUrl)).
-			WithExec([]string{"git", "config", "--global", "credential.helper", "store --file /.git-credentials"})
+		m = m.WithCredential(baseRepoUrl, username, token)
 	}
 	return m, nil
 }
@@ -131,22 +128,154 @@ fi
 	return m, nil
 }
 
-// CommitAndPush permit to commit and push
+// CommitResult is the result of CommitAndPushWithRetry
+type CommitResult struct {
+	// False when there are no changes to commit
+	Changed bool
+
+	// The commit SHA pushed
+	Commit string
+
+	// The remote where the commit is pushed
+	Remote string
+
+	// The branch where the commit is pushed
+	Branch string
+
+	// The number of push attempts
+	Attempts int
+}
+
+// CommitAndPush permit to commit and push on the current branch of origin.
+// When the push is rejected because the remote has new commits, it rebase and retry.
+// It return the pushed commit, or empty string when there are no changes to commit
 func (m *Git) CommitAndPush(
 	ctx context.Context,
 
 	// The commit message
 	message string,
 ) (string, error) {
+	result, err := m.CommitAndPushWithRetry(ctx, message, "origin", "", 3, 2)
+	if err != nil {
+		return "", err
+	}
 
-	return m.BaseContainer.
-		WithExec(helper.ForgeCommand("git add -A")).
-		WithExec(helper.ForgeScript(
-			`
-if [ -n "\$(git status --untracked-files=no --porcelain)" ]; then
-	git commit -m "%s"
-	git push
-fi
-		`, message)).
-		Stdout(ctx)
+	return result.Commit, nil
+}
+
+// CommitAndPushWithRetry permit to commit and push, and return the pushed commit
+// When the push is rejected because the remote has new commits, it rebase and retry
+func (m *Git) CommitAndPushWithRetry(
+	ctx context.Context,
+
+	// The commit message
+	message string,
+
+	// The remote to push on
+	// +optional
+	// +default="origin"
+	remote string,
+
+	// The branch to push on
+	// Default to the current branch
+	// +optional
+	branch string,
+
+	// The number of retries when the push is rejected
+	// +optional
+	// +default=3
+	retries int,
+
+	// The number of seconds to wait before the first retry. It's doubled on each retry
+	// +optional
+	// +default=2
+	backoff int,
+) (*CommitResult, error) {
+	if remote == "" {
+		remote = "origin"
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	// Always commit and push, even if the same inputs have already been pushed
+	ctr := m.BaseContainer.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"git", "add", "-A"})
+
+	status, err := gitOutput(ctx, ctr, "status", "--untracked-files=no", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	if status == "" {
+		return &CommitResult{Changed: false}, nil
+	}
+
+	if branch == "" {
+		if branch, err = gitOutput(ctx, ctr, "rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+			return nil, err
+		}
+		if branch == "HEAD" {
+			return nil, errors.New("The repository is on detached HEAD, you need to set the branch")
+		}
+	}
+
+	ctr = ctr.WithExec([]string{"git", "commit", "-m", message})
+
+	result := &CommitResult{
+		Changed: true,
+		Remote:  remote,
+		Branch:  branch,
+	}
+	wait := time.Duration(backoff) * time.Second
+	for {
+		result.Attempts++
+		ctr = ctr.WithExec(
+			[]string{"git", "push", remote, "HEAD:refs/heads/" + branch},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		)
+		exitCode, err := ctr.ExitCode(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when push")
+		}
+		if exitCode == 0 {
+			break
+		}
+
+		stderr, _ := ctr.Stderr(ctx)
+		if !isPushRejected(stderr) {
+			return nil, errors.Errorf("Error when push on %s/%s: %s", remote, branch, stderr)
+		}
+		if result.Attempts > retries {
+			return nil, errors.Errorf("Error when push on %s/%s after %d attempts: %s", remote, branch, result.Attempts, stderr)
+		}
+
+		// The remote has probably new commits
+		time.Sleep(wait)
+		wait = wait * 2
+		ctr = ctr.WithExec(
+			[]string{"git", "pull", "--rebase", remote, branch},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		)
+		if exitCode, err = ctr.ExitCode(ctx); err != nil {
+			return nil, errors.Wrap(err, "Error when rebase")
+		}
+		if exitCode != 0 {
+			stderr, _ = ctr.Stderr(ctx)
+			return nil, errors.Errorf("Error when rebase on %s/%s, there are conflicts to resolve: %s", remote, branch, stderr)
+		}
+	}
+
+	if result.Commit, err = gitOutput(ctx, ctr, "rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	m.BaseContainer = ctr.WithoutEnvVariable("CACHE_BUSTER")
+
+	return result, nil
+}
+
+// isPushRejected return true when the push is rejected because the remote has new commits
+func isPushRejected(stderr string) bool {
+	return strings.Contains(stderr, "[rejected]") &&
+		(strings.Contains(stderr, "fetch first") || strings.Contains(stderr, "non-fast-forward"))
 }
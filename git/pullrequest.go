@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// PullRequest is the result of CreatePullRequest
+type PullRequest struct {
+	// The pull request number (the merge request IID on GitLab)
+	Number int
+
+	// The pull request URL
+	Url string
+
+	// The branch pushed
+	Branch string
+
+	// The commit SHA pushed
+	Commit string
+
+	// True if the pull request has been opened, false if an existing one has been updated
+	Created bool
+
+	// False when there are no changes to commit, so no pull request is opened
+	Changed bool
+}
+
+// CreatePullRequest commit all changes of the repository set with SetRepo on a new branch, push it and open a pull request.
+// It's idempotent: the branch is force pushed and the existing pull request of the branch is updated.
+func (m *Git) CreatePullRequest(
+	ctx context.Context,
+
+	// The git hosting provider: github, gitlab or gitea
+	provider string,
+
+	// The repository, like owner/repo or group/subgroup/repo on GitLab
+	repository string,
+
+	// The token used to call the API
+	token *dagger.Secret,
+
+	// The branch to create
+	branch string,
+
+	// The pull request title
+	title string,
+
+	// The pull request description
+	// +optional
+	body string,
+
+	// The commit message
+	// Default to the title
+	// +optional
+	message string,
+
+	// The target branch
+	// Default to the current branch
+	// +optional
+	base string,
+
+	// The labels to set
+	// +optional
+	labels []string,
+
+	// The reviewers usernames to request
+	// +optional
+	reviewers []string,
+
+	// The API URL. Required for gitea
+	// +optional
+	apiUrl string,
+) (*PullRequest, error) {
+	if message == "" {
+		message = title
+	}
+
+	client, err := newForge(m.BaseContainer, provider, apiUrl, repository, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == "" {
+		base, err = m.BaseContainer.
+			WithExec([]string{"git", "rev-parse", "--abbrev-ref", "HEAD"}).
+			Stdout(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when get current branch")
+		}
+		base = strings.TrimSpace(base)
+		if base == "HEAD" {
+			return nil, errors.New("The repository is on detached HEAD, you need to set the base branch")
+		}
+	}
+
+	ctr := m.BaseContainer.
+		WithExec([]string{"git", "checkout", "-B", branch}).
+		WithExec([]string{"git", "add", "-A"})
+
+	status, err := ctr.
+		WithExec([]string{"git", "status", "--porcelain", "--untracked-files=no"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when get git status")
+	}
+	if strings.TrimSpace(status) == "" {
+		return &PullRequest{Branch: branch, Changed: false}, nil
+	}
+
+	ctr = ctr.
+		WithExec([]string{"git", "commit", "-m", message}).
+		WithExec([]string{"git", "push", "--force", "origin", "HEAD:refs/heads/" + branch})
+
+	sha, err := ctr.
+		WithExec([]string{"git", "rev-parse", "HEAD"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when commit and push branch %s", branch)
+	}
+	m.BaseContainer = ctr
+
+	spec := &pullRequestSpec{
+		Branch:    branch,
+		Base:      base,
+		Title:     title,
+		Body:      body,
+		Labels:    labels,
+		Reviewers: reviewers,
+	}
+
+	pr, err := client.FindPullRequest(ctx, branch)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when search pull request of branch %s", branch)
+	}
+	if pr == nil {
+		pr, err = client.CreatePullRequest(ctx, spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when create pull request of branch %s", branch)
+		}
+	} else {
+		number := pr.Number
+		pr, err = client.UpdatePullRequest(ctx, pr, spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when update pull request %d", number)
+		}
+	}
+
+	pr.Branch = branch
+	pr.Commit = strings.TrimSpace(sha)
+	pr.Changed = true
+
+	return pr, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"dagger/git/internal/dagger"
+
+	"emperror.dev/errors"
+)
+
+const (
+	signingKeyPath        = "/run/secrets/git/signing.key"
+	signingPassphrasePath = "/run/secrets/git/signing.passphrase"
+	gpgWrapperPath        = "/usr/local/bin/gpg-dagger"
+	gnupgHome             = "/tmp/gnupg"
+)
+
+// gpgWrapper import the signing key on a temporary keyring before each call of gpg.
+// So the private key is never stored on the container filesystem
+const gpgWrapper = `#!/bin/sh
+set -e
+opts="--batch"
+if [ -f "${GIT_SIGNING_PASSPHRASE_FILE}" ]; then
+	opts="${opts} --pinentry-mode loopback --passphrase-file ${GIT_SIGNING_PASSPHRASE_FILE}"
+fi
+gpg ${opts} --quiet --import "${GIT_SIGNING_KEY_FILE}" 2>/dev/null
+exec gpg ${opts} "$@"
+`
+
+// WithSigningKey sign all commits and tags created by the module.
+// The key is only mounted as secret, it's never stored on the container filesystem.
+// The gpg format need gnupg on the base container and the ssh format need ssh-keygen.
+func (m *Git) WithSigningKey(
+	// The private key. An armored GPG private key or an SSH private key
+	key *dagger.Secret,
+
+	// The key format: gpg or ssh
+	// +optional
+	// +default="gpg"
+	format string,
+
+	// The GPG key ID to use. Default to the key matching the committer email
+	// +optional
+	keyId string,
+
+	// The GPG key passphrase. The SSH key need to be unencrypted
+	// +optional
+	passphrase *dagger.Secret,
+) (*Git, error) {
+	if format == "" {
+		format = "gpg"
+	}
+
+	ctr := m.BaseContainer.
+		WithMountedSecret(signingKeyPath, key, dagger.ContainerWithMountedSecretOpts{Owner: "root", Mode: 0600})
+
+	switch format {
+	case "gpg":
+		ctr = ctr.
+			WithNewFile(gpgWrapperPath, gpgWrapper, dagger.ContainerWithNewFileOpts{Permissions: 0755}).
+			WithMountedTemp(gnupgHome).
+			WithEnvVariable("GNUPGHOME", gnupgHome).
+			WithEnvVariable("GIT_SIGNING_KEY_FILE", signingKeyPath).
+			WithExec([]string{"git", "config", "--global", "gpg.format", "openpgp"}).
+			WithExec([]string{"git", "config", "--global", "gpg.program", gpgWrapperPath})
+		if passphrase != nil {
+			ctr = ctr.
+				WithMountedSecret(signingPassphrasePath, passphrase).
+				WithEnvVariable("GIT_SIGNING_PASSPHRASE_FILE", signingPassphrasePath)
+		}
+		if keyId != "" {
+			ctr = ctr.WithExec([]string{"git", "config", "--global", "user.signingkey", keyId})
+		}
+	case "ssh":
+		// ssh-keygen can't read the passphrase without a terminal
+		if passphrase != nil {
+			return nil, errors.New("The passphrase is not supported with ssh format, you need to provide an unencrypted SSH key")
+		}
+		ctr = ctr.
+			WithExec([]string{"git", "config", "--global", "gpg.format", "ssh"}).
+			WithExec([]string{"git", "config", "--global", "user.signingkey", signingKeyPath})
+	default:
+		return nil, errors.Errorf("Signing key format %s not supported, it need to be gpg or ssh", format)
+	}
+
+	m.BaseContainer = ctr.
+		WithExec([]string{"git", "config", "--global", "commit.gpgsign", "true"}).
+		WithExec([]string{"git", "config", "--global", "tag.gpgsign", "true"})
+
+	return m, nil
+}
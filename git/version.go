@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"dagger/git/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/coreos/go-semver/semver"
+)
+
+const (
+	// Separators used to parse git log output
+	logFieldSeparator  = "\x1f"
+	logCommitSeparator = "\x1e"
+)
+
+var conventionalCommitRegexp = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+
+// Sections of changelog, in order
+var changelogSections = []struct {
+	types []string
+	title string
+}{
+	{types: []string{"feat"}, title: "Features"},
+	{types: []string{"fix"}, title: "Bug Fixes"},
+	{types: []string{"perf"}, title: "Performance Improvements"},
+	{types: []string{"revert"}, title: "Reverts"},
+	{types: []string{"refactor"}, title: "Code Refactoring"},
+	{types: []string{"docs"}, title: "Documentation"},
+	{types: []string{"build", "ci"}, title: "Build System"},
+}
+
+// commit is a commit read from git log
+type commit struct {
+	Hash    string
+	Subject string
+	Body    string
+
+	// Conventional commit fields, empty when the subject not follow the convention
+	Type     string
+	Scope    string
+	Summary  string
+	Breaking bool
+}
+
+// NextVersion compute the next semantic version from the conventional commits since the last semver tag.
+// A breaking change bump the major version, a feat bump the minor version and a fix or perf bump the patch version.
+// It return the last version when there are nothing to release.
+// The source directory need the full history with tags
+func (m *Git) NextVersion(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+
+	// The tag prefix
+	// +optional
+	// +default="v"
+	prefix string,
+) (string, error) {
+	ctr := m.BaseContainer.WithDirectory(".", src)
+
+	lastTag, lastVersion, err := lastSemverTag(ctx, ctr, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	revisionRange := "HEAD"
+	if lastTag != "" {
+		revisionRange = fmt.Sprintf("%s..HEAD", lastTag)
+	}
+	commits, err := gitLog(ctx, ctr, revisionRange)
+	if err != nil {
+		return "", err
+	}
+
+	nextVersion := semver.Version{Major: lastVersion.Major, Minor: lastVersion.Minor, Patch: lastVersion.Patch}
+	switch bump(commits) {
+	case "major":
+		nextVersion.BumpMajor()
+	case "minor":
+		nextVersion.BumpMinor()
+	case "patch":
+		nextVersion.BumpPatch()
+	}
+
+	return prefix + nextVersion.String(), nil
+}
+
+// Tag create an annotated tag on the current commit of the repository set with SetRepo, and push it
+// The tag is signed when sign is true or when a signing key is set with WithSigningKey
+func (m *Git) Tag(
+	ctx context.Context,
+
+	// The tag name
+	tag string,
+
+	// The tag message
+	// Default to the tag name
+	// +optional
+	message string,
+
+	// Set true to sign the tag
+	// +optional
+	sign bool,
+
+	// Set true to push the tag on origin
+	// +optional
+	// +default=true
+	push bool,
+) (string, error) {
+	if message == "" {
+		message = tag
+	}
+
+	cmd := []string{"git", "tag", "-a", tag, "-m", message}
+	if sign {
+		cmd = []string{"git", "tag", "-s", tag, "-m", message}
+	}
+
+	ctr := m.BaseContainer.WithExec(cmd)
+	if push {
+		ctr = ctr.WithExec([]string{"git", "push", "origin", fmt.Sprintf("refs/tags/%s", tag)})
+	}
+
+	if _, err := ctr.Sync(ctx); err != nil {
+		return "", errors.Wrapf(err, "Error when create tag %s", tag)
+	}
+
+	m.BaseContainer = ctr
+
+	return tag, nil
+}
+
+// Changelog return the markdown release notes from conventional commits, grouped by type
+func (m *Git) Changelog(
+	ctx context.Context,
+
+	// The source directory
+	src *dagger.Directory,
+
+	// The start revision (excluded)
+	// Default to the last semver tag
+	// +optional
+	from string,
+
+	// The end revision
+	// +optional
+	// +default="HEAD"
+	to string,
+
+	// The version used as changelog title
+	// +optional
+	version string,
+
+	// The tag prefix used to find the last semver tag
+	// +optional
+	// +default="v"
+	prefix string,
+) (string, error) {
+	if to == "" {
+		to = "HEAD"
+	}
+
+	ctr := m.BaseContainer.WithDirectory(".", src)
+
+	if from == "" {
+		lastTag, _, err := lastSemverTag(ctx, ctr, prefix)
+		if err != nil {
+			return "", err
+		}
+		from = lastTag
+	}
+
+	revisionRange := to
+	if from != "" {
+		revisionRange = fmt.Sprintf("%s..%s", from, to)
+	}
+	commits, err := gitLog(ctx, ctr, revisionRange)
+	if err != nil {
+		return "", err
+	}
+
+	return changelog(version, commits), nil
+}
+
+// lastSemverTag return the highest semver tag reachable from HEAD
+// It return the 0.0.0 version when there are no tag
+func lastSemverTag(ctx context.Context, ctr *dagger.Container, prefix string) (string, *semver.Version, error) {
+	stdout, err := ctr.
+		WithExec([]string{"git", "tag", "--list", "--merged", "HEAD"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "Error when list tags")
+	}
+
+	var (
+		lastTag     string
+		lastVersion = &semver.Version{}
+	)
+	for _, tag := range strings.Fields(stdout) {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		version, err := semver.NewVersion(strings.TrimPrefix(tag, prefix))
+		if err != nil || version.PreRelease != "" {
+			continue
+		}
+		if lastVersion.LessThan(*version) {
+			lastTag = tag
+			lastVersion = version
+		}
+	}
+
+	return lastTag, lastVersion, nil
+}
+
+// gitLog return the commits of the revision range, newest first
+func gitLog(ctx context.Context, ctr *dagger.Container, revisionRange string) ([]*commit, error) {
+	stdout, err := ctr.
+		WithExec([]string{"git", "log", "--no-merges", "--format=%H%x1f%s%x1f%b%x1e", revisionRange}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when read git log %s", revisionRange)
+	}
+
+	return parseLog(stdout), nil
+}
+
+// parseLog parse the git log output produced with the format %H%x1f%s%x1f%b%x1e
+func parseLog(log string) []*commit {
+	commits := make([]*commit, 0)
+	for _, entry := range strings.Split(log, logCommitSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.SplitN(entry, logFieldSeparator, 3)
+		for len(fields) < 3 {
+			fields = append(fields, "")
+		}
+		commits = append(commits, parseCommit(fields[0], fields[1], strings.TrimSpace(fields[2])))
+	}
+
+	return commits
+}
+
+// parseCommit parse the conventional commit fields of a commit
+func parseCommit(hash string, subject string, body string) *commit {
+	c := &commit{
+		Hash:    hash,
+		Subject: subject,
+		Body:    body,
+	}
+
+	matches := conventionalCommitRegexp.FindStringSubmatch(subject)
+	if matches == nil {
+		return c
+	}
+	c.Type = strings.ToLower(matches[1])
+	c.Scope = matches[2]
+	c.Breaking = matches[3] == "!"
+	c.Summary = matches[4]
+
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+			c.Breaking = true
+		}
+	}
+
+	return c
+}
+
+// bump return the highest version bump needed by the commits: major, minor, patch or empty
+func bump(commits []*commit) string {
+	result := ""
+	for _, c := range commits {
+		switch {
+		case c.Breaking:
+			return "major"
+		case c.Type == "feat":
+			result = "minor"
+		case (c.Type == "fix" || c.Type == "perf") && result == "":
+			result = "patch"
+		}
+	}
+
+	return result
+}
+
+// changelog render the markdown release notes
+func changelog(version string, commits []*commit) string {
+	b := &strings.Builder{}
+	if version != "" {
+		fmt.Fprintf(b, "## %s\n\n", version)
+	}
+
+	breakings := make([]*commit, 0)
+	for _, c := range commits {
+		if c.Breaking {
+			breakings = append(breakings, c)
+		}
+	}
+	writeChangelogSection(b, "⚠ BREAKING CHANGES", breakings)
+
+	for _, section := range changelogSections {
+		sectionCommits := make([]*commit, 0)
+		for _, c := range commits {
+			for _, t := range section.types {
+				if c.Type == t {
+					sectionCommits = append(sectionCommits, c)
+				}
+			}
+		}
+		writeChangelogSection(b, section.title, sectionCommits)
+	}
+
+	return b.String()
+}
+
+func writeChangelogSection(b *strings.Builder, title string, commits []*commit) {
+	if len(commits) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "### %s\n\n", title)
+	for _, c := range commits {
+		shortHash := c.Hash
+		if len(shortHash) > 7 {
+			shortHash = shortHash[:7]
+		}
+		if c.Scope != "" {
+			fmt.Fprintf(b, "* **%s:** %s (%s)\n", c.Scope, c.Summary, shortHash)
+		} else {
+			fmt.Fprintf(b, "* %s (%s)\n", c.Summary, shortHash)
+		}
+	}
+	b.WriteString("\n")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"dagger/golang/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	cacheArchivePath = "/tmp/go-cache.tar.gz"
+)
+
+var (
+	cacheKeyInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+	goMinorVersionRegexp = regexp.MustCompile(`^\d+\.\d+`)
+)
+
+// cacheKey compute the cache volume prefix from the project namespace and the Go version
+// Only the major and minor version are used, so the caches are kept on patch release
+func cacheKey(namespace string, goVersion string) string {
+	parts := []string{"golang"}
+	if namespace != "" {
+		parts = append(parts, cacheKeyInvalidChars.ReplaceAllString(namespace, "_"))
+	}
+	goVersion = strings.TrimPrefix(goVersion, "go")
+	if minorVersion := goMinorVersionRegexp.FindString(goVersion); minorVersion != "" {
+		goVersion = minorVersion
+	}
+	parts = append(parts, cacheKeyInvalidChars.ReplaceAllString(goVersion, "_"))
+
+	return strings.Join(parts, "-")
+}
+
+// CacheExport return a tarball with the content of the module, build and bin caches.
+// It can be stored as CI artifact and restored with WithCacheImport on ephemeral runners
+func (g *Golang) CacheExport() *dagger.File {
+	return g.Container.
+		WithExec([]string{
+			"tar",
+			"-czf", cacheArchivePath,
+			"-C", "/",
+			strings.TrimPrefix(g.ModCachePath, "/"),
+			strings.TrimPrefix(g.BuildCachePath, "/"),
+			strings.TrimPrefix(g.BinPath, "/"),
+		}).
+		File(cacheArchivePath)
+}
+
+// WithCacheImport restore the module, build and bin caches from a tarball produced by CacheExport
+func (g *Golang) WithCacheImport(
+	// The cache tarball
+	// +required
+	archive *dagger.File,
+) *Golang {
+	g.Container = g.Container.
+		WithMountedFile(cacheArchivePath, archive).
+		WithExec([]string{"tar", "-xzf", cacheArchivePath, "-C", "/"})
+
+	return g
+}
+
+// CachePrune clean the cache volumes used by the current project and Go version
+func (g *Golang) CachePrune(
+	ctx context.Context,
+	// Clean the module cache
+	// +optional
+	// +default=true
+	modCache bool,
+	// Clean the build and test cache
+	// +optional
+	// +default=true
+	buildCache bool,
+	// Remove the installed binaries
+	// +optional
+	// +default=true
+	bin bool,
+) (string, error) {
+	script := []string{"set -e"}
+	if modCache {
+		// The cache volume is mounted, so we can only delete its content. Module files are read only
+		script = append(script,
+			fmt.Sprintf("chmod -R u+w %s", g.ModCachePath),
+			fmt.Sprintf("find %s -mindepth 1 -delete", g.ModCachePath),
+			fmt.Sprintf("echo 'Module cache %s cleaned'", g.ModCachePath),
+		)
+	}
+	if buildCache {
+		script = append(script, "go clean -cache -testcache", fmt.Sprintf("echo 'Build cache %s cleaned'", g.BuildCachePath))
+	}
+	if bin {
+		script = append(script, fmt.Sprintf("find %s -mindepth 1 -delete", g.BinPath), fmt.Sprintf("echo 'Bin directory %s cleaned'", g.BinPath))
+	}
+
+	// Always prune, even if the same prune has already been run
+	return g.Container.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", strings.Join(script, "\n")}).
+		Stdout(ctx)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"dagger/golang/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// GoEnvironment describe the Go environment of the module container
+type GoEnvironment struct {
+	// The Go version defined within go.mod, or provided to the constructor
+	ModVersion string
+
+	// The Go toolchain version (GOVERSION)
+	GoVersion string
+
+	// The target operating system (GOOS)
+	Goos string
+
+	// The target architecture (GOARCH)
+	Goarch string
+
+	// The GOPATH
+	Gopath string
+
+	// The bin path, on cache volume
+	Gobin string
+
+	// The module cache path (GOMODCACHE), on cache volume
+	Gomodcache string
+
+	// The build cache path (GOCACHE), on cache volume
+	Gocache string
+
+	// The Go module proxy (GOPROXY)
+	Goproxy string
+
+	// The private modules (GOPRIVATE)
+	Goprivate string
+
+	// The build flags (GOFLAGS)
+	Goflags string
+
+	// True if a .netrc file is mounted for private modules
+	Netrc bool
+
+	// The extra tools found on the container
+	Tools []*GoTool
+}
+
+// GoTool is a tool installed on the module container
+type GoTool struct {
+	// The tool name
+	Name string
+
+	// True if the tool is installed
+	Installed bool
+
+	// The version reported by the tool
+	Version string
+}
+
+// The tools installed on demand by the module functions
+var goTools = []struct {
+	name string
+	cmd  []string
+}{
+	{name: "gotestsum", cmd: []string{"gotestsum", "--version"}},
+	{name: "golangci-lint", cmd: []string{"golangci-lint", "version"}},
+	{name: "govulncheck", cmd: []string{"govulncheck", "-version"}},
+	{name: "gofumpt", cmd: []string{"gofumpt", "-version"}},
+	{name: "dlv", cmd: []string{"dlv", "version"}},
+}
+
+// Env report the resolved Go environment and installed tools.
+// It's usefull to diagnose a misconfigured base container
+func (g *Golang) Env(ctx context.Context) (*GoEnvironment, error) {
+	ctr := g.Container
+	if g.Private != nil {
+		ctr = g.enablePrivateModules()
+	}
+
+	vars, err := goEnv(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &GoEnvironment{
+		ModVersion: g.Version,
+		GoVersion:  vars["GOVERSION"],
+		Goos:       vars["GOOS"],
+		Goarch:     vars["GOARCH"],
+		Gopath:     vars["GOPATH"],
+		Gobin:      g.BinPath,
+		Gomodcache: vars["GOMODCACHE"],
+		Gocache:    vars["GOCACHE"],
+		Goproxy:    vars["GOPROXY"],
+		Goprivate:  vars["GOPRIVATE"],
+		Goflags:    vars["GOFLAGS"],
+		Netrc:      g.Private != nil,
+	}
+
+	for _, tool := range goTools {
+		env.Tools = append(env.Tools, inspectTool(ctx, ctr, tool.name, tool.cmd))
+	}
+
+	return env, nil
+}
+
+// Doctor return a human readable report of the Go environment and installed tools
+func (g *Golang) Doctor(ctx context.Context) (string, error) {
+	env, err := g.Env(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	report := &strings.Builder{}
+	fmt.Fprintf(report, "go.mod version: %s\n", env.ModVersion)
+	fmt.Fprintf(report, "GOVERSION:      %s\n", env.GoVersion)
+	fmt.Fprintf(report, "GOOS/GOARCH:    %s/%s\n", env.Goos, env.Goarch)
+	fmt.Fprintf(report, "GOPATH:         %s\n", env.Gopath)
+	fmt.Fprintf(report, "GOBIN:          %s\n", env.Gobin)
+	fmt.Fprintf(report, "GOMODCACHE:     %s\n", env.Gomodcache)
+	fmt.Fprintf(report, "GOCACHE:        %s\n", env.Gocache)
+	fmt.Fprintf(report, "GOPROXY:        %s\n", env.Goproxy)
+	fmt.Fprintf(report, "GOPRIVATE:      %s\n", env.Goprivate)
+	fmt.Fprintf(report, "GOFLAGS:        %s\n", env.Goflags)
+	fmt.Fprintf(report, "NETRC:          %t\n", env.Netrc)
+	fmt.Fprintln(report, "Tools:")
+	for _, tool := range env.Tools {
+		if tool.Installed {
+			fmt.Fprintf(report, "  %s: %s\n", tool.Name, tool.Version)
+		} else {
+			fmt.Fprintf(report, "  %s: not installed\n", tool.Name)
+		}
+	}
+
+	return report.String(), nil
+}
+
+// inspectTool run the version command of a tool without failing when the tool is missing
+func inspectTool(ctx context.Context, ctr *dagger.Container, name string, cmd []string) *GoTool {
+	tool := &GoTool{Name: name}
+
+	ctr = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil || exitCode != 0 {
+		return tool
+	}
+
+	stdout, err := ctr.Stdout(ctx)
+	if err != nil {
+		return tool
+	}
+
+	tool.Installed = true
+	tool.Version = strings.TrimSpace(strings.SplitN(stdout, "\n", 2)[0])
+
+	return tool
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMissingVersion is returned when the Go version can't be read from go.mod and is not provided
+var ErrMissingVersion = errors.New("unable to resolve the Go version: set the version argument when the source has no go.mod or no go directive")
+
+// GoEnvError is returned when the Go toolchain of the base container can't be used
+type GoEnvError struct {
+	// The operation that failed
+	Op string
+
+	// The underlying error
+	Err error
+}
+
+func (e *GoEnvError) Error() string {
+	return fmt.Sprintf("%s failed on the base container (check that the base image provides a Go toolchain in PATH, or omit base to use the official golang image): %s", e.Op, e.Err.Error())
+}
+
+func (e *GoEnvError) Unwrap() error {
+	return e.Err
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	"dagger/golang/internal/dagger"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
@@ -54,6 +55,22 @@ type Golang struct {
 	// The volume is put on cache
 	// +private
 	BinPath string
+
+	// The module cache path (GOMODCACHE)
+	// +private
+	ModCachePath string
+
+	// The build cache path (GOCACHE)
+	// +private
+	BuildCachePath string
+
+	// Services bound to the test containers
+	// +private
+	Services []*ServiceBinding
+
+	// Extra environment variables set on the test containers
+	// +private
+	EnvVariables []*EnvVariable
 }
 
 // New initializes the golang dagger module
@@ -65,28 +82,37 @@ func New(
 	// bookworm (> 1.20) variants.
 	// +optional
 	base *dagger.Container,
-	// The golang version to use when no go.mod
+	// The golang version to use when no go.mod, or when go.mod has no go directive
 	// +optional
 	version string,
+	// Set true to use the version even when it's defined within the go.mod file
+	// +optional
+	overrideVersion bool,
 	// a path to a directory containing the source code
 	// +required
 	src *dagger.Directory,
+	// A project name used to isolate the cache volumes from other projects.
+	// The cache volumes are always isolated by Go version
+	// +optional
+	cacheNamespace string,
 ) (*Golang, error) {
-	expectedVersion, err := inspectModVersion(context.Background(), src)
-	if err != nil {
-		if version != "" {
-			expectedVersion = version
-		} else {
-			return nil, err
+	var err error
+	if !overrideVersion || version == "" {
+		modVersion, err := inspectModVersion(ctx, src)
+		if err != nil {
+			if !errors.Is(err, ErrMissingVersion) || version == "" {
+				return nil, err
+			}
+			modVersion = version
 		}
+		version = modVersion
 	}
-	version = expectedVersion
 
 	if base == nil {
 		base = defaultImage(version)
 	} else {
 		if _, err = base.WithoutEntrypoint().WithExec([]string{"go", "version"}).Sync(ctx); err != nil {
-			return nil, err
+			return nil, &GoEnvError{Op: "go version", Err: err}
 		}
 	}
 
@@ -96,7 +122,11 @@ func New(
 	}
 
 	// Ensure cache mounts are configured for any type of image
-	golang.Container = golang.mountCaches(ctx).
+	ctr, err := golang.mountCaches(ctx, cacheNamespace)
+	if err != nil {
+		return nil, err
+	}
+	golang.Container = ctr.
 		WithDirectory(goWorkDir, src).
 		WithWorkdir(goWorkDir).
 		WithoutEntrypoint()
@@ -107,24 +137,25 @@ func New(
 func inspectModVersion(ctx context.Context, src *dagger.Directory) (string, error) {
 	mod, err := src.File(goMod).Contents(ctx)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrMissingVersion, err)
 	}
 
 	f, err := modfile.Parse(goMod, []byte(mod), nil)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("unable to parse %s: %w", goMod, err)
+	}
+
+	// The go directive is optional
+	if f.Go == nil {
+		return "", fmt.Errorf("%w: %s has no go directive", ErrMissingVersion, goMod)
 	}
 	return f.Go.Version, nil
 }
 
-func (h *Golang) mountCaches(ctx context.Context) *dagger.Container {
-	goEnvStdout, err := h.Container.WithExec([]string{"go", "env", "-json"}).Stdout(ctx)
+func (h *Golang) mountCaches(ctx context.Context, namespace string) (*dagger.Container, error) {
+	goEnv, err := goEnv(ctx, h.Container)
 	if err != nil {
-		panic(fmt.Sprintf("Error when get go env; %s", err.Error()))
-	}
-	var goEnv map[string]string
-	if err := json.Unmarshal([]byte(goEnvStdout), &goEnv); err != nil {
-		panic(fmt.Sprintf("Error when decode go env; %s", err.Error()))
+		return nil, err
 	}
 
 	goCacheEnv := goEnv["GOCACHE"]
@@ -134,18 +165,40 @@ func (h *Golang) mountCaches(ctx context.Context) *dagger.Container {
 		goBinCacheEnv = fmt.Sprintf("%s/bin", goEnv["GOPATH"])
 	}
 
-	gomod := dag.CacheVolume("gomod")
-	gobuild := dag.CacheVolume("gobuild")
-	gobin := dag.CacheVolume("gobin")
+	// Isolate caches by Go version to avoid sharing tools build with another toolchain
+	goVersion := goEnv["GOVERSION"]
+	if goVersion == "" {
+		goVersion = h.Version
+	}
+	key := cacheKey(namespace, goVersion)
+	gomod := dag.CacheVolume(key + "-gomod")
+	gobuild := dag.CacheVolume(key + "-gobuild")
+	gobin := dag.CacheVolume(key + "-gobin")
 
 	h.BinPath = goBinCacheEnv
+	h.ModCachePath = goModCacheEnv
+	h.BuildCachePath = goCacheEnv
 
 	h.Container = h.Container.
 		WithMountedCache(goModCacheEnv, gomod).
 		WithMountedCache(goCacheEnv, gobuild).
 		WithMountedCache(goBinCacheEnv, gobin)
 
-	return h.Container
+	return h.Container, nil
+}
+
+// goEnv return the go environment of the container
+func goEnv(ctx context.Context, ctr *dagger.Container) (map[string]string, error) {
+	goEnvStdout, err := ctr.WithExec([]string{"go", "env", "-json"}).Stdout(ctx)
+	if err != nil {
+		return nil, &GoEnvError{Op: "go env -json", Err: err}
+	}
+	var goEnv map[string]string
+	if err := json.Unmarshal([]byte(goEnvStdout), &goEnv); err != nil {
+		return nil, &GoEnvError{Op: "decoding go env -json output", Err: err}
+	}
+
+	return goEnv, nil
 }
 
 // Echoes the version of go defined within a projects go.mod file.
@@ -339,6 +392,8 @@ func (g *Golang) Test(
 		ctr = g.enablePrivateModules()
 	}
 
+	ctr, cmd = g.withTestDependencies(ctr, cmd)
+
 	return ctr.
 		WithExec(cmd).
 		WithExec(helper.ForgeScript(`cat coverage.out.tmp | grep -v "_generated.*.go" > coverage.out`)).
@@ -394,6 +449,8 @@ go install golang.org/x/tools/gopls@latest
 		cmd = append(cmd, "--", "-test.run", run)
 	}
 
+	ctr, cmd = g.withTestDependencies(ctr, cmd)
+
 	return ctr.
 		WithExposedPort(4000).
 		WithEntrypoint(cmd).
@@ -422,6 +479,8 @@ func (g *Golang) Bench(
 		ctr = g.enablePrivateModules()
 	}
 
+	ctr, cmd = g.withTestDependencies(ctr, cmd)
+
 	return ctr.WithExec(cmd).Stdout(ctx)
 }
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"dagger/golang/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Number of seconds to wait for a bound service to be ready
+	serviceReadyTimeout = 120
+)
+
+// The alias is used on the readiness probes, so it need to be a valid hostname
+var serviceAliasRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// ServiceBinding is a service bound to the test containers
+type ServiceBinding struct {
+	// The hostname used to reach the service
+	Alias string
+
+	// The service to bind
+	Service *dagger.Service
+
+	// A shell command that succeed when the service is ready
+	Probe string
+}
+
+// EnvVariable is an environment variable set on the test containers
+type EnvVariable struct {
+	// The variable name
+	Name string
+
+	// The variable value
+	Value string
+}
+
+// WithServiceBinding bind a service on the containers used by Test, DebugTest and Bench
+// Each call will add a new service binding
+func (g *Golang) WithServiceBinding(
+	// The hostname used to reach the service
+	// +required
+	alias string,
+	// The service to bind
+	// +required
+	service *dagger.Service,
+	// A shell command that succeed when the service is ready to be used.
+	// It is run on the test container before starting the tests
+	// +optional
+	probe string,
+) (*Golang, error) {
+	if !serviceAliasRegexp.MatchString(alias) {
+		return nil, fmt.Errorf("invalid service alias %q: it need to be a valid hostname", alias)
+	}
+
+	g.Services = append(g.Services, &ServiceBinding{
+		Alias:   alias,
+		Service: service,
+		Probe:   probe,
+	})
+	return g, nil
+}
+
+// WithEnv set an environment variable on the containers used by Test, DebugTest and Bench
+// Each call will add a new environment variable
+func (g *Golang) WithEnv(
+	// The variable name
+	// +required
+	name string,
+	// The variable value
+	// +required
+	value string,
+) *Golang {
+	g.EnvVariables = append(g.EnvVariables, &EnvVariable{
+		Name:  name,
+		Value: value,
+	})
+	return g
+}
+
+// WithPostgres bind a PostgreSQL service on the test containers
+// The tests start only when the database accept connections
+func (g *Golang) WithPostgres(
+	// The hostname used to reach the database
+	// +optional
+	// +default="postgres"
+	alias string,
+	// The PostgreSQL image tag
+	// +optional
+	// +default="16"
+	version string,
+	// The database user
+	// +optional
+	// +default="postgres"
+	user string,
+	// The database password
+	// +optional
+	// +default="postgres"
+	password string,
+	// The database to create
+	// +optional
+	// +default="test"
+	database string,
+) (*Golang, error) {
+	if alias == "" {
+		alias = "postgres"
+	}
+	if version == "" {
+		version = "16"
+	}
+	if user == "" {
+		user = "postgres"
+	}
+	if password == "" {
+		password = "postgres"
+	}
+	if database == "" {
+		database = "test"
+	}
+
+	service := dag.Container().
+		From(fmt.Sprintf("postgres:%s", version)).
+		WithEnvVariable("POSTGRES_USER", user).
+		WithEnvVariable("POSTGRES_PASSWORD", password).
+		WithEnvVariable("POSTGRES_DB", database).
+		WithExposedPort(5432).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+
+	// The entrypoint only listen on TCP when the database initialization is finished
+	return g.WithServiceBinding(alias, service, fmt.Sprintf("(exec 3<>/dev/tcp/%s/5432) 2>/dev/null", alias))
+}
+
+// WithRedis bind a Redis service on the test containers
+// The tests start only when Redis answer to PING
+func (g *Golang) WithRedis(
+	// The hostname used to reach Redis
+	// +optional
+	// +default="redis"
+	alias string,
+	// The Redis image tag
+	// +optional
+	// +default="7"
+	version string,
+) (*Golang, error) {
+	if alias == "" {
+		alias = "redis"
+	}
+	if version == "" {
+		version = "7"
+	}
+
+	service := dag.Container().
+		From(fmt.Sprintf("redis:%s", version)).
+		WithExposedPort(6379).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+
+	return g.WithServiceBinding(alias, service, fmt.Sprintf(`(exec 3<>/dev/tcp/%s/6379 && printf 'PING\r\n' >&3 && timeout 2 head -c 7 <&3 | grep -q PONG) 2>/dev/null`, alias))
+}
+
+// WithKafka bind a Kafka compatible broker (Redpanda) on the test containers
+// The broker listen on port 9092. The tests start only when the broker is ready
+func (g *Golang) WithKafka(
+	// The hostname used to reach the broker
+	// +optional
+	// +default="kafka"
+	alias string,
+	// The Redpanda image tag
+	// +optional
+	// +default="v24.2.7"
+	version string,
+) (*Golang, error) {
+	if alias == "" {
+		alias = "kafka"
+	}
+	if version == "" {
+		version = "v24.2.7"
+	}
+
+	service := dag.Container().
+		From(fmt.Sprintf("docker.redpanda.com/redpandadata/redpanda:%s", version)).
+		WithExposedPort(9092).
+		WithExposedPort(9644).
+		AsService(dagger.ContainerAsServiceOpts{
+			Args: []string{
+				"redpanda",
+				"start",
+				"--mode", "dev-container",
+				"--smp", "1",
+				"--kafka-addr", "internal://0.0.0.0:9092",
+				"--advertise-kafka-addr", fmt.Sprintf("internal://%s:9092", alias),
+			},
+			UseEntrypoint: true,
+		})
+
+	return g.WithServiceBinding(alias, service, fmt.Sprintf("curl -sf http://%s:9644/v1/status/ready >/dev/null", alias))
+}
+
+// withTestDependencies bind services and set environment variables on the container.
+// The command is wrapped to wait the services readiness before running
+func (g *Golang) withTestDependencies(ctr *dagger.Container, cmd []string) (*dagger.Container, []string) {
+	for _, env := range g.EnvVariables {
+		ctr = ctr.WithEnvVariable(env.Name, env.Value)
+	}
+
+	probes := make([]string, 0, len(g.Services))
+	for _, binding := range g.Services {
+		ctr = ctr.WithServiceBinding(binding.Alias, binding.Service)
+		if binding.Probe != "" {
+			probes = append(probes, fmt.Sprintf(`
+n=0
+until %s; do
+	n=$((n+1))
+	if [ $n -ge %d ]; then
+		echo "Service %s is not ready after %d seconds" >&2
+		exit 1
+	fi
+	sleep 1
+done`, binding.Probe, serviceReadyTimeout, binding.Alias, serviceReadyTimeout))
+		}
+	}
+
+	if len(probes) == 0 {
+		return ctr, cmd
+	}
+
+	script := strings.Join(probes, "\n") + "\nexec \"$@\""
+	return ctr, append([]string{"bash", "-c", script, "wait-services"}, cmd...)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/disaster37/dagger-library-go/lib/helper"
+	"github.com/gookit/validate"
+)
+
+const (
+	chartTestingNamespace  = "chart-testing"
+	chartTestingKubeconfig = "/tmp/kubeconfig"
+)
+
+type ChartTestingLintOption struct {
+	Source              *dagger.Directory `validate:"required"`
+	Config              *dagger.File
+	ValidateMaintainers bool
+	WithFiles           []*dagger.File
+}
+
+type ChartTestingInstallOption struct {
+	Source          *dagger.Directory `validate:"required"`
+	Kubeconfig      *dagger.File
+	Config          *dagger.File
+	PreviousChart   string
+	PreviousVersion string
+	WithFiles       []*dagger.File
+}
+
+// ChartTestingResult is the result of chart-testing install
+type ChartTestingResult struct {
+	// True if install, tests and upgrade passed
+	Passed bool
+
+	// The chart-testing and helm output
+	Output string
+
+	// The events of the cluster, only when failed
+	Events string
+
+	// The logs of the pods, only when failed
+	Logs string
+}
+
+// ChartTestingLint permit to run ct lint on helm chart: helm lint, yamllint and Chart.yaml schema
+func (m *Helm) ChartTestingLint(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The chart-testing config file (ct.yaml)
+	// +optional
+	config *dagger.File,
+
+	// Set true to validate the maintainers
+	// +optional
+	validateMaintainers bool,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (stdout string, err error) {
+	option := &ChartTestingLintOption{
+		Source:              source,
+		Config:              config,
+		ValidateMaintainers: validateMaintainers,
+		WithFiles:           withFiles,
+	}
+
+	if err = defaults.Set(option); err != nil {
+		return "", err
+	}
+
+	if err = validate.Struct(option).ValidateErr(); err != nil {
+		return "", err
+	}
+
+	cmd := []string{"ct", "lint", "--charts", "/project", "--check-version-increment=false", fmt.Sprintf("--validate-maintainers=%t", option.ValidateMaintainers)}
+
+	container := m.chartTestingContainer(option.Source, option.WithFiles)
+	if option.Config != nil {
+		container = container.WithFile("/tmp/ct.yaml", option.Config)
+		cmd = append(cmd, "--config", "/tmp/ct.yaml")
+	}
+
+	stdout, err = container.
+		WithExec(cmd).
+		Stdout(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "Error when lint chart with chart-testing")
+	}
+
+	return stdout, nil
+}
+
+// ChartTestingInstall permit to run ct install on helm chart. It install the chart with each ci/*-values.yaml,
+// run helm test and optionally upgrade from the previous published version.
+// When no kubeconfig is provided, it start a k3s cluster
+func (m *Helm) ChartTestingInstall(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The kubeconfig to connect on existing cluster
+	// If not set, it will run local k3s cluster
+	// +optional
+	kubeconfig *dagger.File,
+
+	// The chart-testing config file (ct.yaml)
+	// +optional
+	config *dagger.File,
+
+	// The previous published chart, like oci://registry/repository/chart, to test the upgrade
+	// +optional
+	previousChart string,
+
+	// The previous published chart version. Default to the latest version
+	// +optional
+	previousVersion string,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (*ChartTestingResult, error) {
+	option := &ChartTestingInstallOption{
+		Source:          source,
+		Kubeconfig:      kubeconfig,
+		Config:          config,
+		PreviousChart:   previousChart,
+		PreviousVersion: previousVersion,
+		WithFiles:       withFiles,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	if option.Kubeconfig == nil {
+		// The cluster name is derived from the source, to not share the cluster between runs
+		digest, err := option.Source.Digest(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when compute source digest")
+		}
+		name := fmt.Sprintf("helm-chart-testing-%s", strings.TrimPrefix(digest, "sha256:")[:12])
+
+		// Force rancher image because of issue https://github.com/k3s-io/k3s/issues/11857
+		kube := dag.K3S(name, dagger.K3SOpts{Image: "rancher/k3s:v1.31.5-k3s1"})
+		server, err := kube.Server(dagger.K3SServerOpts{
+			ClusterCidr: "10.44.0.0/16",
+			ServiceCird: "10.45.0.0/16",
+		}).Start(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when start K3s")
+		}
+		defer func() {
+			_, _ = server.Stop(ctx)
+		}()
+		option.Kubeconfig = kube.Config()
+	}
+
+	container := m.chartTestingContainer(option.Source, option.WithFiles).
+		WithFile(chartTestingKubeconfig, option.Kubeconfig).
+		WithEnvVariable("KUBECONFIG", chartTestingKubeconfig).
+		WithExec(helper.ForgeScript("kubectl create namespace %[1]s --dry-run=client -o yaml | kubectl apply -f -", chartTestingNamespace))
+
+	cmd := []string{"ct", "install", "--charts", "/project", "--namespace", chartTestingNamespace, "--release-label", "app.kubernetes.io/instance", "--skip-clean-up"}
+	if option.Config != nil {
+		container = container.WithFile("/tmp/ct.yaml", option.Config)
+		cmd = append(cmd, "--config", "/tmp/ct.yaml")
+	}
+
+	container = container.WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	passed, output, err := execResult(ctx, container)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when run chart-testing install")
+	}
+
+	// Upgrade from the previous published version with each values file
+	if passed && option.PreviousChart != "" {
+		previousVersionArg := ""
+		if option.PreviousVersion != "" {
+			previousVersionArg = fmt.Sprintf("--version %s", option.PreviousVersion)
+		}
+		container = container.WithExec(helper.ForgeScript(`
+set -e
+files=$(ls ci/*-values.yaml 2>/dev/null || echo values.yaml)
+i=0
+for file in ${files}; do
+	i=$((i+1))
+	release=upgrade-${i}
+	echo "==> Upgrade test from %[1]s with ${file}"
+	helm install ${release} %[1]s %[2]s --namespace %[3]s --values ${file} --wait --timeout 5m
+	helm upgrade ${release} . --namespace %[3]s --values ${file} --wait --timeout 5m
+	helm test ${release} --namespace %[3]s --logs
+	helm uninstall ${release} --namespace %[3]s --wait
+done
+`, option.PreviousChart, previousVersionArg, chartTestingNamespace), dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+
+		var upgradeOutput string
+		passed, upgradeOutput, err = execResult(ctx, container)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when run upgrade test")
+		}
+		output += upgradeOutput
+	}
+
+	result := &ChartTestingResult{
+		Passed: passed,
+		Output: output,
+	}
+
+	// Get the events and the logs to debug
+	if !result.Passed {
+		if result.Events, err = container.
+			WithExec(helper.ForgeCommandf("kubectl get events --namespace %s --sort-by=.lastTimestamp", chartTestingNamespace)).
+			Stdout(ctx); err != nil {
+			return nil, errors.Wrap(err, "Error when get events")
+		}
+		if result.Logs, err = container.
+			WithExec(helper.ForgeScript("for pod in $(kubectl get pods --namespace %[1]s -o name); do echo \"==> Logs of ${pod}\"; kubectl logs --namespace %[1]s ${pod} --all-containers --prefix || true; done", chartTestingNamespace)).
+			Stdout(ctx); err != nil {
+			return nil, errors.Wrap(err, "Error when get logs")
+		}
+	}
+
+	return result, nil
+}
+
+// execResult return if the last command succeeded and its output
+func execResult(ctx context.Context, container *dagger.Container) (passed bool, output string, err error) {
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	stdout, err := container.Stdout(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	stderr, err := container.Stderr(ctx)
+	if err != nil {
+		return false, "", err
+	}
+
+	return exitCode == 0, stdout + stderr, nil
+}
+
+// chartTestingContainer return the chart-testing container with the chart on a git repository, as ct need it
+func (m *Helm) chartTestingContainer(source *dagger.Directory, withFiles []*dagger.File) *dagger.Container {
+	return m.BaseChartTestingContainer.
+		WithDirectory("/project", source).
+		WithWorkdir("/project").
+		WithFiles("/project", withFiles).
+		WithExec(helper.ForgeScript("[ -d .git ] || (git init -q && git add -A && git -c user.name=ct -c user.email=ct@localhost commit -q -m ct)"))
+}
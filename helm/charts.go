@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/gookit/validate"
+	"gopkg.in/yaml.v3"
+)
+
+type ChartsOption struct {
+	Source  *dagger.Directory `validate:"required"`
+	Path    string            `default:"."`
+	BaseRef string
+}
+
+type ProcessChartsOption struct {
+	Source                *dagger.Directory `validate:"required"`
+	Path                  string            `default:"."`
+	BaseRef               string
+	Lint                  bool
+	GenerateDocumentation bool
+	GenerateSchema        bool
+	ConfigFile            string
+	Push                  bool
+	RegistryUrl           string
+	RepositoryName        string
+	Version               string
+	Backend               string `default:"oci" validate:"in:oci,chartmuseum,harbor"`
+	Username              *dagger.Secret
+	Password              *dagger.Secret
+	WithFiles             []*dagger.File
+}
+
+// Chart is a helm chart discovered on the source directory
+type Chart struct {
+	// The chart name
+	Name string
+
+	// The chart path, relative to the source directory
+	Path string
+
+	// The chart version
+	Version string
+
+	// The path of the discovered charts it depend on through file://
+	LocalDependencies []string
+
+	// True if the chart or one of its local dependencies changed since the base ref
+	Changed bool
+}
+
+// ChartResult is the result of the processing of one chart
+type ChartResult struct {
+	// The chart name
+	Name string
+
+	// The chart path, relative to the source directory
+	Path string
+
+	// The chart version
+	Version string
+
+	// True if all steps passed
+	Passed bool
+
+	// The output of each step
+	Output string
+
+	// The error, only when failed
+	Error string
+}
+
+// ChartsResult is the aggregated result of the processing of the charts
+type ChartsResult struct {
+	// True if all charts passed
+	Passed bool
+
+	// The result of each chart, in processing order
+	Charts []*ChartResult
+
+	// The source directory with the generated documentation, schema and Chart.yaml
+	Source *dagger.Directory
+}
+
+// chartMetadata is the part of Chart.yaml needed to discover the charts
+type chartMetadata struct {
+	Name         string            `yaml:"name"`
+	Version      string            `yaml:"version"`
+	Dependencies []chartDependency `yaml:"dependencies"`
+}
+
+// Charts permit to discover all charts under the path, in dependency order.
+// When the base ref is set, only the charts changed since it are returned. The source directory need to contain the .git directory
+func (m *Helm) Charts(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The path where to discover the charts, relative to the source directory
+	// +optional
+	// +default="."
+	path string,
+
+	// The git base ref, like origin/main, to only return the changed charts
+	// +optional
+	baseRef string,
+) ([]*Chart, error) {
+	option := &ChartsOption{
+		Source:  source,
+		Path:    path,
+		BaseRef: baseRef,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	charts, err := discoverCharts(ctx, option.Source, option.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if option.BaseRef == "" {
+		for _, chart := range charts {
+			chart.Changed = true
+		}
+		return charts, nil
+	}
+
+	changedFiles, err := dag.GitLibrary().ChangedFiles(ctx, option.Source, option.BaseRef, dagger.GitLibraryChangedFilesOpts{Uncommitted: true})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get changed files since %s", option.BaseRef)
+	}
+
+	// Charts are sorted, so the dependencies are already computed
+	changedCharts := make([]*Chart, 0, len(charts))
+	chartsByPath := make(map[string]*Chart, len(charts))
+	for _, chart := range charts {
+		chartsByPath[chart.Path] = chart
+		for _, file := range changedFiles {
+			if chart.Path == "." || strings.HasPrefix(file, chart.Path+"/") {
+				chart.Changed = true
+				break
+			}
+		}
+		for _, dependency := range chart.LocalDependencies {
+			if chartsByPath[dependency].Changed {
+				chart.Changed = true
+			}
+		}
+		if chart.Changed {
+			changedCharts = append(changedCharts, chart)
+		}
+	}
+
+	return changedCharts, nil
+}
+
+// ProcessCharts permit to run lint, schema generation, documentation generation and push on each chart under the path.
+// The charts are processed in dependency order, and a chart is skipped when one of its local dependencies failed
+// It will return the result of each chart and the source directory with the generated files
+func (m *Helm) ProcessCharts(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The path where to discover the charts, relative to the source directory
+	// +optional
+	// +default="."
+	path string,
+
+	// The git base ref, like origin/main, to only process the changed charts
+	// +optional
+	baseRef string,
+
+	// Set true to lint the charts
+	// +optional
+	lint bool,
+
+	// Set true to generate the README.md of the charts
+	// +optional
+	generateDocumentation bool,
+
+	// Set true to generate the values.schema.json of the charts
+	// +optional
+	generateSchema bool,
+
+	// Config file for readme-generator, relative to the chart directory
+	// +optional
+	configFile string,
+
+	// Set true to push the charts
+	// +optional
+	push bool,
+
+	// The registry url. The host for oci and harbor, the base URL for chartmuseum
+	// +optional
+	registryUrl string,
+
+	// The repository name. The repository for oci, the project for harbor and the optional tenant for chartmuseum
+	// +optional
+	repositoryName string,
+
+	// The version to push. Default to the version of each Chart.yaml
+	// +optional
+	version string,
+
+	// The registry backend: oci, chartmuseum or harbor
+	// +optional
+	// +default="oci"
+	backend string,
+
+	// The registry username
+	// +optional
+	username *dagger.Secret,
+
+	// The registry password
+	// +optional
+	password *dagger.Secret,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (*ChartsResult, error) {
+	option := &ProcessChartsOption{
+		Source:                source,
+		Path:                  path,
+		BaseRef:               baseRef,
+		Lint:                  lint,
+		GenerateDocumentation: generateDocumentation,
+		GenerateSchema:        generateSchema,
+		ConfigFile:            configFile,
+		Push:                  push,
+		RegistryUrl:           registryUrl,
+		RepositoryName:        repositoryName,
+		Version:               version,
+		Backend:               backend,
+		Username:              username,
+		Password:              password,
+		WithFiles:             withFiles,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	if option.Push && option.RegistryUrl == "" {
+		return nil, errors.New("The registryUrl is required to push the charts")
+	}
+
+	charts, err := m.Charts(ctx, option.Source, option.Path, option.BaseRef)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ChartsResult{
+		Passed: true,
+		Charts: make([]*ChartResult, 0, len(charts)),
+		Source: option.Source,
+	}
+	failedCharts := make(map[string]bool, len(charts))
+
+	for _, chart := range charts {
+		chartResult := &ChartResult{
+			Name:    chart.Name,
+			Path:    chart.Path,
+			Version: chart.Version,
+			Passed:  true,
+		}
+		result.Charts = append(result.Charts, chartResult)
+
+		for _, dependency := range chart.LocalDependencies {
+			if failedCharts[dependency] {
+				chartResult.Passed = false
+				chartResult.Error = fmt.Sprintf("Skipped because the dependency %s failed", dependency)
+				break
+			}
+		}
+
+		if chartResult.Passed {
+			chartSource, output, err := m.processChart(ctx, option, chart, result.Source.Directory(chart.Path))
+			chartResult.Output = output
+			if err != nil {
+				chartResult.Passed = false
+				chartResult.Error = err.Error()
+			} else {
+				result.Source = result.Source.WithDirectory(chart.Path, chartSource)
+			}
+		}
+
+		if !chartResult.Passed {
+			failedCharts[chart.Path] = true
+			result.Passed = false
+		}
+	}
+
+	return result, nil
+}
+
+// processChart run the expected steps on one chart
+// It return the chart source directory with the generated files and the output of each step
+func (m *Helm) processChart(ctx context.Context, option *ProcessChartsOption, chart *Chart, chartSource *dagger.Directory) (*dagger.Directory, string, error) {
+	output := new(strings.Builder)
+
+	// The local dependencies need to be reachable from the chart directory
+	chartHelm, err := m.withLocalDependencies(ctx, option.Source, chart.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if option.Lint {
+		stdout, err := chartHelm.Lint(ctx, chartSource, option.WithFiles)
+		if err != nil {
+			return nil, output.String(), errors.Wrap(err, "Error when lint chart")
+		}
+		fmt.Fprintf(output, "==> Lint\n%s\n", stdout)
+	}
+
+	if option.GenerateSchema {
+		schemaFile, err := chartHelm.GenerateSchema(chartSource, option.ConfigFile)
+		if err != nil {
+			return nil, output.String(), err
+		}
+		if _, err = schemaFile.Sync(ctx); err != nil {
+			return nil, output.String(), errors.Wrap(err, "Error when generate schema")
+		}
+		chartSource = chartSource.WithFile("values.schema.json", schemaFile)
+		fmt.Fprint(output, "==> Generated values.schema.json\n")
+	}
+
+	if option.GenerateDocumentation {
+		readmeFile, err := chartHelm.GenerateDocumentation(chartSource, option.ConfigFile)
+		if err != nil {
+			return nil, output.String(), err
+		}
+		if _, err = readmeFile.Sync(ctx); err != nil {
+			return nil, output.String(), errors.Wrap(err, "Error when generate documentation")
+		}
+		chartSource = chartSource.WithFile("README.md", readmeFile)
+		fmt.Fprint(output, "==> Generated README.md\n")
+	}
+
+	if option.Push {
+		version := option.Version
+		if version == "" {
+			version = chart.Version
+		}
+		chartFile, err := chartHelm.Push(ctx, chartSource, option.RegistryUrl, option.RepositoryName, version, option.Backend, option.Username, option.Password, option.WithFiles)
+		if err != nil {
+			return nil, output.String(), err
+		}
+		if option.Version != "" {
+			chartSource = chartSource.WithFile("Chart.yaml", chartFile)
+		}
+		fmt.Fprintf(output, "==> Pushed %s:%s\n", chart.Name, version)
+	}
+
+	return chartSource, output.String(), nil
+}
+
+// withLocalDependencies return a copy of helm with the file:// dependencies of the chart mounted on the helm container,
+// at the same relative location from /project
+func (m *Helm) withLocalDependencies(ctx context.Context, source *dagger.Directory, chartPath string) (*Helm, error) {
+	helm := *m
+	visited := map[string]bool{chartPath: true}
+
+	var mount func(repositoryPath string, containerPath string) error
+	mount = func(repositoryPath string, containerPath string) error {
+		dependencies, err := localDependencies(ctx, source, repositoryPath)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			dependencyRepositoryPath := path.Join(repositoryPath, dependency)
+			if dependencyRepositoryPath == ".." || strings.HasPrefix(dependencyRepositoryPath, "../") {
+				return errors.Errorf("The local dependency %s of %s is outside the source directory", dependency, repositoryPath)
+			}
+			if visited[dependencyRepositoryPath] {
+				continue
+			}
+			visited[dependencyRepositoryPath] = true
+
+			dependencyContainerPath := path.Join(containerPath, dependency)
+			if !strings.HasPrefix(dependencyContainerPath+"/", "/project/") {
+				helm.BaseHelmContainer = helm.BaseHelmContainer.WithDirectory(dependencyContainerPath, source.Directory(dependencyRepositoryPath))
+			}
+			if err = mount(dependencyRepositoryPath, dependencyContainerPath); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := mount(chartPath, "/project"); err != nil {
+		return nil, err
+	}
+
+	return &helm, nil
+}
+
+// discoverCharts return the charts under the path, sorted in dependency order
+// The subcharts inside another chart are ignored
+func discoverCharts(ctx context.Context, source *dagger.Directory, chartsPath string) ([]*Chart, error) {
+	// The ** pattern not always match the chart on the path itself
+	found := make(map[string]bool)
+	for _, pattern := range []string{"Chart.yaml", "**/Chart.yaml"} {
+		chartFiles, err := source.Glob(ctx, path.Join(chartsPath, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "Error when discover charts")
+		}
+		for _, chartFile := range chartFiles {
+			found[path.Dir(path.Clean(chartFile))] = true
+		}
+	}
+
+	chartPaths := make([]string, 0, len(found))
+	for chartPath := range found {
+		chartPaths = append(chartPaths, chartPath)
+	}
+	sort.Strings(chartPaths)
+
+	charts := make([]*Chart, 0, len(chartPaths))
+	chartsByPath := make(map[string]*Chart, len(chartPaths))
+	for _, chartPath := range chartPaths {
+		isSubchart := false
+		for _, chart := range charts {
+			if chart.Path == "." || strings.HasPrefix(chartPath, chart.Path+"/") {
+				isSubchart = true
+				break
+			}
+		}
+		if isSubchart {
+			continue
+		}
+
+		metadata, err := readChartMetadata(ctx, source, chartPath)
+		if err != nil {
+			return nil, err
+		}
+		chart := &Chart{
+			Name:    metadata.Name,
+			Path:    chartPath,
+			Version: metadata.Version,
+		}
+		charts = append(charts, chart)
+		chartsByPath[chartPath] = chart
+	}
+
+	for _, chart := range charts {
+		dependencies, err := localDependencies(ctx, source, chart.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, dependency := range dependencies {
+			dependencyPath := path.Join(chart.Path, dependency)
+			if _, ok := chartsByPath[dependencyPath]; ok {
+				chart.LocalDependencies = append(chart.LocalDependencies, dependencyPath)
+			}
+		}
+	}
+
+	return sortCharts(charts)
+}
+
+// sortCharts sort the charts to have the dependencies before the charts that use them
+func sortCharts(charts []*Chart) ([]*Chart, error) {
+	sortedCharts := make([]*Chart, 0, len(charts))
+	sorted := make(map[string]bool, len(charts))
+
+	for len(sortedCharts) < len(charts) {
+		progress := false
+		for _, chart := range charts {
+			if sorted[chart.Path] {
+				continue
+			}
+			ready := true
+			for _, dependency := range chart.LocalDependencies {
+				if !sorted[dependency] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sortedCharts = append(sortedCharts, chart)
+				sorted[chart.Path] = true
+				progress = true
+			}
+		}
+		if !progress {
+			cycle := make([]string, 0)
+			for _, chart := range charts {
+				if !sorted[chart.Path] {
+					cycle = append(cycle, chart.Path)
+				}
+			}
+			return nil, errors.Errorf("Cyclic local dependencies between charts: %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return sortedCharts, nil
+}
+
+// readChartMetadata read the Chart.yaml file of the chart
+func readChartMetadata(ctx context.Context, source *dagger.Directory, chartPath string) (*chartMetadata, error) {
+	chartContents, err := source.File(path.Join(chartPath, "Chart.yaml")).Contents(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when read chart file of %s", chartPath)
+	}
+	metadata := &chartMetadata{}
+	if err = yaml.Unmarshal([]byte(chartContents), metadata); err != nil {
+		return nil, errors.Wrapf(err, "Error when decode YAML file of %s", chartPath)
+	}
+
+	return metadata, nil
+}
+
+// localDependencies return the relative path of the file:// dependencies of the chart
+func localDependencies(ctx context.Context, source *dagger.Directory, chartPath string) ([]string, error) {
+	metadata, err := readChartMetadata(ctx, source, chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := make([]string, 0, len(metadata.Dependencies))
+	for _, dependency := range metadata.Dependencies {
+		if strings.HasPrefix(dependency.Repository, "file://") {
+			dependencies = append(dependencies, path.Clean(strings.TrimPrefix(dependency.Repository, "file://")))
+		}
+	}
+
+	return dependencies, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/disaster37/dagger-library-go/lib/helper"
+	"github.com/gookit/validate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	helmCacheDir    = "/cache/helm"
+	helmCacheVolume = "helm-cache"
+)
+
+var exactVersionRegexp = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+type UpdateDependenciesOption struct {
+	Source    *dagger.Directory `validate:"required"`
+	Strategy  string            `default:"minor" validate:"in:patch,minor,major"`
+	WithFiles []*dagger.File
+}
+
+// WithLockedDependencies permit to pull the chart dependencies from Chart.lock with helm dependency build,
+// instead of helm dependency update. It fail if Chart.lock is missing or out of sync with Chart.yaml
+func (m *Helm) WithLockedDependencies() *Helm {
+	m.LockedDependencies = true
+
+	return m
+}
+
+// UpdateDependencies permit to bump the chart dependencies within their semver constraints.
+// The exact versions of Chart.yaml are bumped according to the strategy, the ranges are only resolved on Chart.lock
+// It will return the directory with the updated Chart.yaml and Chart.lock files
+func (m *Helm) UpdateDependencies(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The bump strategy for exact versions: patch (~x.y.z), minor (^x.y.z) or major (>=x.y.z)
+	// +optional
+	// +default="minor"
+	strategy string,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (*dagger.Directory, error) {
+	option := &UpdateDependenciesOption{
+		Source:    source,
+		Strategy:  strategy,
+		WithFiles: withFiles,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	chartContents, err := option.Source.File("Chart.yaml").Contents(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when read chart file")
+	}
+	chart := &struct {
+		Dependencies []chartDependency `yaml:"dependencies"`
+	}{}
+	if err = yaml.Unmarshal([]byte(chartContents), chart); err != nil {
+		return nil, errors.Wrap(err, "Error when decode YAML file")
+	}
+
+	// Relax the exact versions to resolve the latest one within the constraint
+	constraints := make([]string, 0, len(chart.Dependencies))
+	exactIndexes := make([]int, 0, len(chart.Dependencies))
+	for i, dependency := range chart.Dependencies {
+		if !exactVersionRegexp.MatchString(dependency.Version) {
+			continue
+		}
+		version := strings.TrimPrefix(dependency.Version, "v")
+		switch option.Strategy {
+		case "patch":
+			version = "~" + version
+		case "minor":
+			version = "^" + version
+		case "major":
+			version = ">=" + version
+		}
+		constraints = append(constraints, fmt.Sprintf(".dependencies[%d].version = \"%s\"", i, version))
+		exactIndexes = append(exactIndexes, i)
+	}
+
+	relaxedChartFile := option.Source.File("Chart.yaml")
+	if len(constraints) > 0 {
+		relaxedChartFile = m.yqChart(option.Source.File("Chart.yaml"), strings.Join(constraints, " | "))
+	}
+
+	container := m.withHelmCache(m.BaseHelmContainer).
+		WithDirectory("/project", option.Source).
+		WithWorkdir("/project").
+		WithFiles("/project", option.WithFiles)
+
+	lockFile := container.
+		WithFile("Chart.yaml", relaxedChartFile).
+		WithExec(helper.ForgeCommand("helm dependency update")).
+		File("Chart.lock")
+
+	lockContents, err := lockFile.Contents(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when update dependencies")
+	}
+	lock := &struct {
+		Dependencies []chartDependency `yaml:"dependencies"`
+	}{}
+	if err = yaml.Unmarshal([]byte(lockContents), lock); err != nil {
+		return nil, errors.Wrap(err, "Error when decode YAML file")
+	}
+	if len(lock.Dependencies) != len(chart.Dependencies) {
+		return nil, errors.Errorf("Chart.lock have %d dependencies, but Chart.yaml have %d", len(lock.Dependencies), len(chart.Dependencies))
+	}
+
+	// Pin the exact versions with the resolved ones
+	chartFile := option.Source.File("Chart.yaml")
+	pins := make([]string, 0, len(exactIndexes))
+	for _, i := range exactIndexes {
+		if lock.Dependencies[i].Name != chart.Dependencies[i].Name {
+			return nil, errors.Errorf("Chart.lock dependency %s not match Chart.yaml dependency %s", lock.Dependencies[i].Name, chart.Dependencies[i].Name)
+		}
+		pins = append(pins, fmt.Sprintf(".dependencies[%d].version = \"%s\"", i, lock.Dependencies[i].Version))
+	}
+	if len(pins) > 0 {
+		chartFile = m.yqChart(chartFile, strings.Join(pins, " | "))
+
+		// The Chart.lock digest is computed from Chart.yaml dependencies, so it need to be generated again
+		// from the pinned Chart.yaml, else helm dependency build fail because it's out of sync
+		lockFile = container.
+			WithFile("Chart.yaml", chartFile).
+			WithExec(helper.ForgeCommand("helm dependency update")).
+			File("Chart.lock")
+	}
+
+	return dag.Directory().
+		WithFile("Chart.yaml", chartFile).
+		WithFile("Chart.lock", lockFile), nil
+}
+
+// withHelmCache mount the cache volume used by helm to store the repositories index and the pulled charts
+func (m *Helm) withHelmCache(container *dagger.Container) *dagger.Container {
+	return container.
+		WithMountedCache(helmCacheDir, dag.CacheVolume(helmCacheVolume)).
+		WithEnvVariable("HELM_CACHE_HOME", helmCacheDir)
+}
+
+// withDependencies pull the chart dependencies on the working directory.
+// With locked dependencies, it use Chart.lock and fail on drift, else it resolve them and update Chart.lock
+func (m *Helm) withDependencies(container *dagger.Container) *dagger.Container {
+	container = m.withHelmCache(container)
+
+	if !m.LockedDependencies {
+		return container.WithExec(helper.ForgeCommand("helm dependency update"))
+	}
+
+	// helm dependency build fail when Chart.lock is out of sync with Chart.yaml
+	return container.WithExec(helper.ForgeScript(`
+set -e
+if grep -q "^dependencies:" Chart.yaml && [ ! -f Chart.lock ]; then
+	echo "Chart.lock not found, you need to run UpdateDependencies" >&2
+	exit 1
+fi
+helm dependency build
+`))
+}
+
+// dependencyCommand return the helm dependency subcommand according to the locked dependencies mode
+func (m *Helm) dependencyCommand() string {
+	if m.LockedDependencies {
+		return "build"
+	}
+
+	return "update"
+}
+
+// yqChart apply the yq expression on the Chart.yaml file
+func (m *Helm) yqChart(chartFile *dagger.File, expression string) *dagger.File {
+	return m.BaseYqContainer.
+		WithFile("/project/Chart.yaml", chartFile).
+		WithWorkdir("/project").
+		WithExec(
+			[]string{"yq", "--inplace", expression, "Chart.yaml"},
+			dagger.ContainerWithExecOpts{InsecureRootCapabilities: true},
+		).
+		File("Chart.yaml")
+}
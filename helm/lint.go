@@ -50,8 +50,7 @@ func (m *Helm) Lint(
 		container = container.WithFile(fileName, file)
 	}
 
-	return container.
-		WithExec(helper.ForgeCommand("helm dependency update")).
+	return m.withDependencies(container).
 		WithExec(helper.ForgeCommand("helm lint .")).
 		Stdout(ctx)
 }
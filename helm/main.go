@@ -25,14 +25,52 @@ import (
 )
 
 type Helm struct {
-	BaseHelmContainer      *dagger.Container
-	BaseGeneratorContainer *dagger.Container
-	BaseYqContainer        *dagger.Container
+	BaseHelmContainer         *dagger.Container
+	BaseGeneratorContainer    *dagger.Container
+	BaseYqContainer           *dagger.Container
+	BaseKubeconformContainer  *dagger.Container
+	BaseChartTestingContainer *dagger.Container
+
+	// +private
+	SigningKey *dagger.Secret
+
+	// +private
+	SigningKeyName string
+
+	// +private
+	SigningPassphrase *dagger.Secret
+
+	// +private
+	CosignKey *dagger.Secret
+
+	// +private
+	CosignPassword *dagger.Secret
+
+	// +private
+	CosignIdentityToken *dagger.Secret
+
+	// +private
+	LockedDependencies bool
+
+	// +private
+	OciRegistries []*OciRegistry
+}
+
+// OciRegistry is the OCI registry credentials set with WithRepository
+type OciRegistry struct {
+	// The registry url
+	Url string
+
+	// The registry username
+	Username *dagger.Secret
+
+	// The registry password
+	Password *dagger.Secret
 }
 
 func New(
 	// base helm container
-	// It need contain helm
+	// It need contain helm, and curl for the chartmuseum backend
 	// +optional
 	baseHelmContainer *dagger.Container,
 
@@ -45,6 +83,16 @@ func New(
 	// It need contain yq
 	// +optional
 	baseYqContainer *dagger.Container,
+
+	// Base kubeconform container
+	// It need contain kubeconform
+	// +optional
+	baseKubeconformContainer *dagger.Container,
+
+	// Base chart-testing container
+	// It need contain ct, helm, kubectl and git
+	// +optional
+	baseChartTestingContainer *dagger.Container,
 ) *Helm {
 	helm := &Helm{}
 
@@ -66,6 +114,18 @@ func New(
 		helm.BaseYqContainer = helm.GetBaseYqContainer()
 	}
 
+	if baseKubeconformContainer != nil {
+		helm.BaseKubeconformContainer = baseKubeconformContainer
+	} else {
+		helm.BaseKubeconformContainer = helm.GetBaseKubeconformContainer()
+	}
+
+	if baseChartTestingContainer != nil {
+		helm.BaseChartTestingContainer = baseChartTestingContainer
+	} else {
+		helm.BaseChartTestingContainer = helm.GetBaseChartTestingContainer()
+	}
+
 	return helm
 }
 
@@ -79,7 +139,8 @@ func (m *Helm) GetBaseGeneratorContainer() *dagger.Container {
 // BaseHelmContainer return the default image for helm
 func (m *Helm) GetBaseHelmContainer() *dagger.Container {
 	return dag.Container().
-		From("alpine/helm:3.14.3")
+		From("alpine/helm:3.14.3").
+		WithExec(helper.ForgeCommand("apk add --no-cache curl"))
 }
 
 // BaseYqContainer return the default image for yq
@@ -88,6 +149,18 @@ func (m *Helm) GetBaseYqContainer() *dagger.Container {
 		From("mikefarah/yq:4.35.2")
 }
 
+// BaseKubeconformContainer return the default image for kubeconform
+func (m *Helm) GetBaseKubeconformContainer() *dagger.Container {
+	return dag.Container().
+		From("ghcr.io/yannh/kubeconform:v0.6.7-alpine")
+}
+
+// BaseChartTestingContainer return the default image for chart-testing
+func (m *Helm) GetBaseChartTestingContainer() *dagger.Container {
+	return dag.Container().
+		From("quay.io/helmpack/chart-testing:v3.12.0")
+}
+
 // WithRepository permit to login on private helm repository
 func (m *Helm) WithRepository(
 	ctx context.Context,
@@ -121,9 +194,30 @@ func (m *Helm) WithRepository(
 		WithSecretVariable(passwordEnv, password)
 	if isOci {
 		m.BaseHelmContainer = m.BaseHelmContainer.WithExec(helper.ForgeScript("helm registry login -u ${%s} -p ${%s} %s", usernameEnv, passwordEnv, url))
+		// Keep the credentials to login with cosign
+		if username != nil && password != nil {
+			m.OciRegistries = append(m.OciRegistries, &OciRegistry{
+				Url:      url,
+				Username: username,
+				Password: password,
+			})
+		}
 	} else {
 		m.BaseHelmContainer = m.BaseHelmContainer.WithExec(helper.ForgeScript("helm repo add --username ${%s} --password ${%s} %s %s", usernameEnv, passwordEnv, name, url))
 	}
 
 	return m
 }
+
+// WithService permit to bind a service on helm container, like a local chart repository
+func (m *Helm) WithService(
+	// The service alias
+	alias string,
+
+	// The service
+	service *dagger.Service,
+) *Helm {
+	m.BaseHelmContainer = m.BaseHelmContainer.WithServiceBinding(alias, service)
+
+	return m
+}
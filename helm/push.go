@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"dagger/helm/internal/dagger"
 
@@ -15,11 +17,22 @@ import (
 type PushOption struct {
 	Source         *dagger.Directory `validate:"required"`
 	RegistryUrl    string            `validate:"required"`
-	RepositoryName string            `validate:"required"`
-	Version        string            `validate:"required"`
+	RepositoryName string
+	Version        string `validate:"required"`
+	Backend        string `default:"oci" validate:"in:oci,chartmuseum,harbor"`
+	Username       *dagger.Secret
+	Password       *dagger.Secret
 	WithFiles      []*dagger.File
 }
 
+type PublishIndexOption struct {
+	Source    *dagger.Directory `validate:"required"`
+	Url       string            `validate:"required"`
+	Version   string            `validate:"required"`
+	Index     *dagger.Directory
+	WithFiles []*dagger.File
+}
+
 // Push helm chart on registry
 // It will return the updated Chart.yaml file with the expected version
 func (m *Helm) Push(
@@ -28,15 +41,28 @@ func (m *Helm) Push(
 	// the source directory
 	source *dagger.Directory,
 
-	// The registry url
+	// The registry url. The host for oci and harbor, the base URL for chartmuseum
 	registryUrl string,
 
-	// The repository name
+	// The repository name. The repository for oci, the project for harbor and the optional tenant for chartmuseum
 	repositoryName string,
 
 	// The version
 	version string,
 
+	// The registry backend: oci, chartmuseum or harbor
+	// +optional
+	// +default="oci"
+	backend string,
+
+	// The registry username. Not needed for oci and harbor when login with WithRepository
+	// +optional
+	username *dagger.Secret,
+
+	// The registry password
+	// +optional
+	password *dagger.Secret,
+
 	// Files to inject on containers
 	// +optional
 	withFiles []*dagger.File,
@@ -47,6 +73,9 @@ func (m *Helm) Push(
 		RegistryUrl:    registryUrl,
 		RepositoryName: repositoryName,
 		Version:        version,
+		Backend:        backend,
+		Username:       username,
+		Password:       password,
 		WithFiles:      withFiles,
 	}
 
@@ -58,41 +87,184 @@ func (m *Helm) Push(
 		return nil, err
 	}
 
+	container, chartFile, chartName, err := m.packageChart(ctx, option.Source, option.Version, option.WithFiles)
+	if err != nil {
+		return nil, err
+	}
+	packageName := fmt.Sprintf("%s-%s.tgz", chartName, option.Version)
+
+	// Use the credentials set with WithRepository when they are not provided
+	if option.Backend == "harbor" && (option.Username == nil || option.Password == nil) {
+		option.Username, option.Password = m.registryCredentials(registryHost(option.RegistryUrl))
+	}
+
+	if option.Username != nil && option.Password != nil {
+		container = container.
+			WithSecretVariable("REGISTRY_USERNAME", option.Username).
+			WithSecretVariable("REGISTRY_PASSWORD", option.Password)
+	}
+
+	ociReference := ""
+	switch option.Backend {
+	case "oci":
+		if option.RepositoryName == "" {
+			return nil, errors.New("The repositoryName is required with oci backend")
+		}
+		if option.Username != nil && option.Password != nil {
+			container = container.WithExec(helper.ForgeScript(`printf '%%s' "${REGISTRY_PASSWORD}" | helm registry login -u "${REGISTRY_USERNAME}" --password-stdin %s`, option.RegistryUrl))
+		}
+		ociReference = fmt.Sprintf("%s/%s/%s", option.RegistryUrl, option.RepositoryName, chartName)
+	case "harbor":
+		if option.RepositoryName == "" {
+			return nil, errors.New("The repositoryName is required with harbor backend, it's the harbor project")
+		}
+		if option.Username == nil || option.Password == nil {
+			return nil, errors.New("The username and password are required with harbor backend, or need to be set with WithRepository")
+		}
+		container = container.WithExec(helper.ForgeScript(`printf '%%s' "${REGISTRY_PASSWORD}" | helm registry login -u "${REGISTRY_USERNAME}" --password-stdin %s`, option.RegistryUrl))
+		ociReference = fmt.Sprintf("%s/%s/%s", option.RegistryUrl, option.RepositoryName, chartName)
+	case "chartmuseum":
+		apiUrl := fmt.Sprintf("%s/api/charts", strings.TrimSuffix(option.RegistryUrl, "/"))
+		if option.RepositoryName != "" {
+			apiUrl = fmt.Sprintf("%s/api/%s/charts", strings.TrimSuffix(option.RegistryUrl, "/"), option.RepositoryName)
+		}
+		auth := ""
+		if option.Username != nil && option.Password != nil {
+			auth = `-u "${REGISTRY_USERNAME}:${REGISTRY_PASSWORD}"`
+		}
+		// Upload the provenance file with the chart when it's signed
+		container = container.WithExec(helper.ForgeScript(`
+set -e
+if [ -f %[2]s.prov ]; then
+	curl -sSf %[1]s -F chart=@%[2]s -F prov=@%[2]s.prov %[3]s
+else
+	curl -sSf %[1]s --data-binary @%[2]s %[3]s
+fi
+`, auth, packageName, apiUrl))
+	}
+
+	// Helm push upload the provenance file too when it exist
+	if ociReference != "" {
+		container = container.WithExec(helper.ForgeScript("helm push %s oci://%s/%s 2>&1", packageName, option.RegistryUrl, option.RepositoryName))
+	}
+
+	stdout, err := container.Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when package and push helm chart")
+	}
+
+	if ociReference != "" && (m.CosignKey != nil || m.CosignIdentityToken != nil) {
+		if err = m.cosignSign(ctx, stdout, ociReference, option.Username, option.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	return chartFile, nil
+
+}
+
+// PublishIndex package helm chart and merge it on the index.yaml of static helm repository, like Github pages
+// It will return the repository directory with the chart package and the updated index.yaml
+func (m *Helm) PublishIndex(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The URL of the static helm repository
+	url string,
+
+	// The version
+	version string,
+
+	// The repository directory with the current index.yaml, like the gh-pages branch
+	// +optional
+	index *dagger.Directory,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (*dagger.Directory, error) {
+	option := &PublishIndexOption{
+		Source:    source,
+		Url:       url,
+		Version:   version,
+		Index:     index,
+		WithFiles: withFiles,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	container, _, chartName, err := m.packageChart(ctx, option.Source, option.Version, option.WithFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index only the new package and its provenance file, and merge it with the current index
+	container = container.
+		WithExec(helper.ForgeCommand("mkdir -p /tmp/new /repository")).
+		WithExec(helper.ForgeScript("cp %s-%s.tgz* /tmp/new/", chartName, option.Version))
+	if option.Index != nil {
+		container = container.WithDirectory("/repository", option.Index)
+	}
+
+	repository := container.
+		WithExec(helper.ForgeScript(`
+set -e
+if [ -f /repository/index.yaml ]; then
+	helm repo index /tmp/new --url %[1]s --merge /repository/index.yaml
+else
+	helm repo index /tmp/new --url %[1]s
+fi
+cp /tmp/new/* /repository/
+`, option.Url)).
+		Directory("/repository")
+
+	if _, err = repository.Sync(ctx); err != nil {
+		return nil, errors.Wrap(err, "Error when package and index helm chart")
+	}
+
+	return repository, nil
+}
+
+// packageChart set the chart version and package it
+// It return the container with the package on working directory, the updated Chart.yaml file and the chart name
+func (m *Helm) packageChart(ctx context.Context, source *dagger.Directory, version string, withFiles []*dagger.File) (container *dagger.Container, chartFile *dagger.File, chartName string, err error) {
 	// Update the chart version
 	chartFile = m.UpdateChart(
 		ctx,
 		source,
 		".version",
-		option.Version,
+		version,
 	)
 
 	chartContends, err := chartFile.Contents(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error when read chart file")
+		return nil, nil, "", errors.Wrap(err, "Error when read chart file")
 	}
 	// Read chart file to get the chart name
 	dataChart := make(map[string]any)
 	if err = yaml.Unmarshal([]byte(chartContends), &dataChart); err != nil {
-		return nil, errors.Wrap(err, "Error when decode YAML file")
+		return nil, nil, "", errors.Wrap(err, "Error when decode YAML file")
 	}
-	chartName := dataChart["name"].(string)
+	chartName = dataChart["name"].(string)
 
-	// Package and push
-
-	_, err = m.BaseHelmContainer.
-		WithDirectory("/project", source).
-		WithWorkdir("/project").
-		WithFile("Chart.yaml", chartFile).
-		WithFiles("/project", option.WithFiles).
-		WithExec(helper.ForgeCommand("helm dependency update")).
-		WithExec(helper.ForgeCommand("helm package -u .")).
-		WithExec(helper.ForgeCommandf("helm push %s-%s.tgz oci://%s/%s", chartName, option.Version, option.RegistryUrl, option.RepositoryName)).
-		Stdout(ctx)
-
-	if err != nil {
-		return nil, errors.Wrap(err, "Error when package and push helm chart")
-	}
-
-	return chartFile, nil
+	// Package
+	container = m.withPackage(
+		m.withDependencies(
+			m.BaseHelmContainer.
+				WithDirectory("/project", source).
+				WithWorkdir("/project").
+				WithFile("Chart.yaml", chartFile).
+				WithFiles("/project", withFiles),
+		),
+	)
 
+	return container, chartFile, chartName, nil
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/disaster37/dagger-library-go/lib/helper"
+)
+
+const (
+	signingKeyPath        = "/run/secrets/helm/signing.key"
+	signingPassphrasePath = "/run/secrets/helm/passphrase"
+	cosignImage           = "ghcr.io/sigstore/cosign/cosign:v2.4.1"
+	cosignBaseImage       = "alpine:3.21"
+)
+
+var helmPushDigestRegexp = regexp.MustCompile(`Digest:\s+(sha256:[a-f0-9]+)`)
+
+// WithSigningKey permit to sign the chart package with GPG and produce the .prov file
+func (m *Helm) WithSigningKey(
+	// The armored GPG private key
+	key *dagger.Secret,
+
+	// The key name, as expected by helm package --key
+	name string,
+
+	// The key passphrase
+	// +optional
+	passphrase *dagger.Secret,
+) *Helm {
+	m.SigningKey = key
+	m.SigningKeyName = name
+	m.SigningPassphrase = passphrase
+
+	return m
+}
+
+// WithCosign permit to sign the OCI chart with cosign after push
+// Use key to sign with a key pair, or identityToken for keyless signing
+func (m *Helm) WithCosign(
+	// The cosign private key
+	// +optional
+	key *dagger.Secret,
+
+	// The cosign private key password
+	// +optional
+	password *dagger.Secret,
+
+	// The OIDC identity token for keyless signing
+	// +optional
+	identityToken *dagger.Secret,
+) (*Helm, error) {
+	if key == nil && identityToken == nil {
+		return nil, errors.New("You need to provide the key or the identityToken")
+	}
+
+	m.CosignKey = key
+	m.CosignPassword = password
+	m.CosignIdentityToken = identityToken
+
+	return m, nil
+}
+
+// withPackage package the chart, and sign it when a signing key is provided
+func (m *Helm) withPackage(container *dagger.Container) *dagger.Container {
+	if m.SigningKey == nil {
+		return container.WithExec(helper.ForgeCommand("helm package ."))
+	}
+
+	passphraseArg := ""
+	container = container.WithMountedSecret(signingKeyPath, m.SigningKey)
+	if m.SigningPassphrase != nil {
+		container = container.WithMountedSecret(signingPassphrasePath, m.SigningPassphrase)
+		passphraseArg = fmt.Sprintf("--passphrase-file %s", signingPassphrasePath)
+	}
+
+	// Helm need the legacy secret keyring format
+	return container.
+		WithEnvVariable("SIGNING_KEY_NAME", m.SigningKeyName).
+		WithExec(helper.ForgeScript(`
+set -e
+command -v gpg > /dev/null || apk add --no-cache gnupg
+export GNUPGHOME=$(mktemp -d)
+gpg --batch --quiet --import %[1]s
+gpg --batch --pinentry-mode loopback %[2]s --export-secret-keys > ${GNUPGHOME}/secring.gpg
+helm package . --sign --key "${SIGNING_KEY_NAME}" --keyring ${GNUPGHOME}/secring.gpg %[2]s
+rm -rf ${GNUPGHOME}
+`, signingKeyPath, passphraseArg))
+}
+
+// cosignSign sign the OCI artifact pushed by helm push
+func (m *Helm) cosignSign(ctx context.Context, pushOutput string, reference string, username *dagger.Secret, password *dagger.Secret) error {
+	match := helmPushDigestRegexp.FindStringSubmatch(pushOutput)
+	if match == nil {
+		return errors.Errorf("Error when read the digest of %s from helm push output", reference)
+	}
+	reference = fmt.Sprintf("%s@%s", reference, match[1])
+
+	container := m.cosignContainer().
+		WithEnvVariable("COSIGN_YES", "true")
+
+	// Use the credentials set with WithRepository when they are not provided on push
+	host := registryHost(reference)
+	if username == nil || password == nil {
+		username, password = m.registryCredentials(host)
+	}
+	container = withCosignLogin(container, host, username, password)
+
+	script := "set -e\n"
+	if m.CosignKey != nil {
+		container = container.WithSecretVariable("COSIGN_PRIVATE_KEY", m.CosignKey)
+		if m.CosignPassword != nil {
+			container = container.WithSecretVariable("COSIGN_PASSWORD", m.CosignPassword)
+		} else {
+			container = container.WithEnvVariable("COSIGN_PASSWORD", "")
+		}
+		script += fmt.Sprintf("cosign sign --key env://COSIGN_PRIVATE_KEY %s\n", reference)
+	} else {
+		// cosign read the identity token from SIGSTORE_ID_TOKEN
+		container = container.WithSecretVariable("SIGSTORE_ID_TOKEN", m.CosignIdentityToken)
+		script += fmt.Sprintf("cosign sign %s\n", reference)
+	}
+
+	if _, err := container.WithExec(helper.ForgeScript("%s", script)).Stdout(ctx); err != nil {
+		return errors.Wrapf(err, "Error when sign %s with cosign", reference)
+	}
+
+	return nil
+}
+
+// cosignContainer return the container with the pinned cosign.
+// The cosign image is distroless, so we only get the binary from it
+func (m *Helm) cosignContainer() *dagger.Container {
+	return dag.Container().
+		From(cosignBaseImage).
+		WithFile("/usr/local/bin/cosign", dag.Container().From(cosignImage).File("/ko-app/cosign"))
+}
+
+// registryCredentials return the credentials set with WithRepository for the registry host, or nil if not found
+func (m *Helm) registryCredentials(host string) (username *dagger.Secret, password *dagger.Secret) {
+	for _, registry := range m.OciRegistries {
+		if registryHost(registry.Url) == host {
+			return registry.Username, registry.Password
+		}
+	}
+
+	return nil, nil
+}
+
+// withCosignLogin login cosign on the registry host when the credentials are provided
+func withCosignLogin(container *dagger.Container, host string, username *dagger.Secret, password *dagger.Secret) *dagger.Container {
+	if username == nil || password == nil {
+		return container
+	}
+
+	return container.
+		WithSecretVariable("REGISTRY_USERNAME", username).
+		WithSecretVariable("REGISTRY_PASSWORD", password).
+		WithExec(helper.ForgeScript(`printf '%%s' "${REGISTRY_PASSWORD}" | cosign login %s -u "${REGISTRY_USERNAME}" --password-stdin`, host)).
+		WithoutSecretVariable("REGISTRY_USERNAME").
+		WithoutSecretVariable("REGISTRY_PASSWORD")
+}
+
+// registryHost return the registry host of OCI reference or registry url
+func registryHost(reference string) string {
+	reference = strings.TrimPrefix(reference, "oci://")
+	reference = strings.TrimPrefix(strings.TrimPrefix(reference, "https://"), "http://")
+	host, _, _ := strings.Cut(reference, "/")
+
+	return host
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/helm/internal/dagger"
+
+	"github.com/creasty/defaults"
+	"github.com/gookit/validate"
+)
+
+const templateOutputDir = "/tmp/manifests"
+
+type TemplateOption struct {
+	Source            *dagger.Directory `validate:"required"`
+	ReleaseName       string            `default:"release"`
+	Namespace         string
+	KubernetesVersion string
+	ValuesFiles       []*dagger.File
+	Set               []string
+	WithFiles         []*dagger.File
+}
+
+// Template permit to render helm chart
+// It will return the directory with the rendered manifests
+func (m *Helm) Template(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The release name
+	// +optional
+	// +default="release"
+	releaseName string,
+
+	// The release namespace
+	// +optional
+	namespace string,
+
+	// The kubernetes version used for Capabilities.KubeVersion
+	// +optional
+	kubernetesVersion string,
+
+	// The values files, in order of precedence
+	// +optional
+	valuesFiles []*dagger.File,
+
+	// The values to set, as key=value
+	// +optional
+	set []string,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (manifests *dagger.Directory, err error) {
+	option := &TemplateOption{
+		Source:            source,
+		ReleaseName:       releaseName,
+		Namespace:         namespace,
+		KubernetesVersion: kubernetesVersion,
+		ValuesFiles:       valuesFiles,
+		Set:               set,
+		WithFiles:         withFiles,
+	}
+
+	if err = defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err = validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	cmd := []string{"helm", "template", option.ReleaseName, ".", "--output-dir", templateOutputDir}
+	if option.Namespace != "" {
+		cmd = append(cmd, "--namespace", option.Namespace)
+	}
+	if option.KubernetesVersion != "" {
+		cmd = append(cmd, "--kube-version", option.KubernetesVersion)
+	}
+
+	container := m.BaseHelmContainer.
+		WithDirectory("/project", option.Source).
+		WithWorkdir("/project").
+		WithFiles("/project", option.WithFiles)
+
+	for i, file := range option.ValuesFiles {
+		fileName, err := file.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+		valuesPath := fmt.Sprintf("/tmp/values/%d-%s", i, fileName)
+		container = container.WithFile(valuesPath, file)
+		cmd = append(cmd, "--values", valuesPath)
+	}
+
+	for _, value := range option.Set {
+		cmd = append(cmd, "--set", value)
+	}
+
+	manifests = m.withDependencies(container).
+		WithExec(cmd).
+		Directory(templateOutputDir)
+
+	return manifests, nil
+}
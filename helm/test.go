@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/disaster37/dagger-library-go/lib/helper"
+	"github.com/gookit/validate"
+)
+
+const (
+	helmUnittestVersion = "0.7.2"
+	helmUnittestDir     = "/tmp/helm-unittest"
+)
+
+type TestOption struct {
+	Source         *dagger.Directory `validate:"required"`
+	ValuesFiles    []*dagger.File
+	UpdateSnapshot bool
+	WithFiles      []*dagger.File
+}
+
+// TestResult is the result of helm unittest
+type TestResult struct {
+	// True if all tests passed
+	Passed bool
+
+	// The helm unittest output
+	Summary string
+
+	// The JUnit XML report
+	Report *dagger.File
+
+	// The tests/__snapshot__ directory, updated when updateSnapshot is set
+	Snapshots *dagger.Directory
+}
+
+// GetHelmUnittestContainer return the helm container with helm-unittest plugin
+func (m *Helm) GetHelmUnittestContainer() *dagger.Container {
+	return m.BaseHelmContainer.
+		WithExec(helper.ForgeCommandf("helm plugin install https://github.com/helm-unittest/helm-unittest.git --version %s", helmUnittestVersion))
+}
+
+// Test permit to run helm unittest on helm chart
+// It will return the JUnit report and the summary
+func (m *Helm) Test(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The values files to use on all tests
+	// +optional
+	valuesFiles []*dagger.File,
+
+	// Set true to update the snapshots
+	// +optional
+	updateSnapshot bool,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (*TestResult, error) {
+	option := &TestOption{
+		Source:         source,
+		ValuesFiles:    valuesFiles,
+		UpdateSnapshot: updateSnapshot,
+		WithFiles:      withFiles,
+	}
+
+	if err := defaults.Set(option); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(option).ValidateErr(); err != nil {
+		return nil, err
+	}
+
+	reportPath := fmt.Sprintf("%s/report.xml", helmUnittestDir)
+	cmd := []string{"helm", "unittest", ".", "--color=false", "--output-type", "JUnit", "--output-file", reportPath}
+
+	container := m.GetHelmUnittestContainer().
+		WithDirectory("/project", option.Source).
+		WithWorkdir("/project").
+		WithFiles("/project", option.WithFiles).
+		WithExec(helper.ForgeCommandf("mkdir -p %s", helmUnittestDir))
+
+	for i, file := range option.ValuesFiles {
+		fileName, err := file.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+		valuesPath := fmt.Sprintf("%s/values/%d-%s", helmUnittestDir, i, fileName)
+		container = container.WithFile(valuesPath, file)
+		cmd = append(cmd, "--values", valuesPath)
+	}
+
+	if option.UpdateSnapshot {
+		cmd = append(cmd, "--update-snapshot")
+	}
+
+	container = m.withDependencies(container).
+		WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when run helm unittest")
+	}
+
+	summary, err := container.Stdout(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when read helm unittest output")
+	}
+
+	result := &TestResult{
+		Passed:    exitCode == 0,
+		Summary:   summary,
+		Report:    container.File(reportPath),
+		Snapshots: dag.Directory(),
+	}
+
+	if option.UpdateSnapshot {
+		result.Snapshots = container.Directory("/project/tests/__snapshot__")
+	}
+
+	return result, nil
+}
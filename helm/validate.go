@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/gookit/validate"
+)
+
+const (
+	kubeconformSchemasDir    = "/schemas"
+	kubeconformCrdSchemasDir = "/crd-schemas"
+)
+
+type ValidateOption struct {
+	Source               *dagger.Directory `validate:"required"`
+	KubernetesVersion    string            `default:"1.30.0"`
+	ValuesFiles          []*dagger.File
+	Set                  []string
+	Schemas              *dagger.Directory
+	CrdSchemas           *dagger.Directory
+	Strict               bool
+	IgnoreMissingSchemas bool
+	Skip                 []string
+	WithFiles            []*dagger.File
+}
+
+// Validate permit to render helm chart and validate the manifests with kubeconform
+func (m *Helm) Validate(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The target kubernetes version
+	// +optional
+	// +default="1.30.0"
+	kubernetesVersion string,
+
+	// The values files, in order of precedence
+	// +optional
+	valuesFiles []*dagger.File,
+
+	// The values to set, as key=value
+	// +optional
+	set []string,
+
+	// The local kubernetes JSON schemas directory, with the layout of yannh/kubernetes-json-schema,
+	// like v1.30.0-standalone-strict/deployment-apps-v1.json. When set, the schemas are not downloaded
+	// +optional
+	schemas *dagger.Directory,
+
+	// The CRD JSON schemas directory, with the layout of datreeio/CRDs-catalog,
+	// like monitoring.coreos.com/prometheusrule_v1.json
+	// +optional
+	crdSchemas *dagger.Directory,
+
+	// Set true to disallow additional properties not in schema or duplicated keys
+	// +optional
+	strict bool,
+
+	// Set true to skip the resources without schema
+	// +optional
+	ignoreMissingSchemas bool,
+
+	// The kinds or GroupVersion/Kind to skip
+	// +optional
+	skip []string,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (stdout string, err error) {
+	option := &ValidateOption{
+		Source:               source,
+		KubernetesVersion:    kubernetesVersion,
+		ValuesFiles:          valuesFiles,
+		Set:                  set,
+		Schemas:              schemas,
+		CrdSchemas:           crdSchemas,
+		Strict:               strict,
+		IgnoreMissingSchemas: ignoreMissingSchemas,
+		Skip:                 skip,
+		WithFiles:            withFiles,
+	}
+
+	if err = defaults.Set(option); err != nil {
+		return "", err
+	}
+
+	if err = validate.Struct(option).ValidateErr(); err != nil {
+		return "", err
+	}
+
+	manifests, err := m.Template(ctx, option.Source, "", "", option.KubernetesVersion, option.ValuesFiles, option.Set, option.WithFiles)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := []string{"/kubeconform", "-summary", "-output", "text", "-kubernetes-version", option.KubernetesVersion}
+
+	container := m.BaseKubeconformContainer.
+		WithDirectory(templateOutputDir, manifests)
+
+	if option.Schemas != nil {
+		container = container.WithDirectory(kubeconformSchemasDir, option.Schemas)
+		cmd = append(cmd, "-schema-location", kubeconformSchemasDir+"/{{ .NormalizedKubernetesVersion }}-standalone{{ .StrictSuffix }}/{{ .ResourceKind }}{{ .KindSuffix }}.json")
+	} else {
+		cmd = append(cmd, "-schema-location", "default")
+	}
+
+	if option.CrdSchemas != nil {
+		container = container.WithDirectory(kubeconformCrdSchemasDir, option.CrdSchemas)
+		cmd = append(cmd, "-schema-location", kubeconformCrdSchemasDir+"/{{ .Group }}/{{ .ResourceKind }}_{{ .ResourceAPIVersion }}.json")
+	}
+
+	if option.Strict {
+		cmd = append(cmd, "-strict")
+	}
+	if option.IgnoreMissingSchemas {
+		cmd = append(cmd, "-ignore-missing-schemas")
+	}
+	if len(option.Skip) > 0 {
+		cmd = append(cmd, "-skip", strings.Join(option.Skip, ","))
+	}
+
+	stdout, err = container.
+		WithExec(append(cmd, templateOutputDir)).
+		Stdout(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "Error when validate manifests with kubeconform")
+	}
+
+	return stdout, nil
+}
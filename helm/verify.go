@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/helm/internal/dagger"
+
+	"emperror.dev/errors"
+	"github.com/creasty/defaults"
+	"github.com/disaster37/dagger-library-go/lib/helper"
+	"github.com/gookit/validate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	verifyKeyringPath   = "/tmp/verify/keyring"
+	verifyCosignKeyPath = "/tmp/verify/cosign.pub"
+)
+
+type VerifyOption struct {
+	Source                    *dagger.Directory `validate:"required"`
+	Keyring                   *dagger.File
+	CosignKey                 *dagger.File
+	CertificateIdentityRegexp string
+	CertificateOidcIssuer     string
+	WithFiles                 []*dagger.File
+}
+
+// chartDependency is a dependency of Chart.yaml
+type chartDependency struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	Repository string `yaml:"repository"`
+}
+
+// Verify permit to check the signatures of the chart dependencies before pulling them.
+// The OCI dependencies are verified with cosign, then helm dependency update (or build with locked dependencies) verify the provenance files with the GPG keyring
+// It will return the output of the verification
+func (m *Helm) Verify(
+	ctx context.Context,
+
+	// the source directory
+	source *dagger.Directory,
+
+	// The GPG public keyring or armored public key, to verify the provenance files
+	// +optional
+	keyring *dagger.File,
+
+	// The cosign public key, to verify the OCI dependencies
+	// +optional
+	cosignKey *dagger.File,
+
+	// The certificate identity regexp, to verify the OCI dependencies signed with keyless cosign
+	// +optional
+	certificateIdentityRegexp string,
+
+	// The certificate OIDC issuer, to verify the OCI dependencies signed with keyless cosign
+	// +optional
+	certificateOidcIssuer string,
+
+	// Files to inject on containers
+	// +optional
+	withFiles []*dagger.File,
+) (stdout string, err error) {
+	option := &VerifyOption{
+		Source:                    source,
+		Keyring:                   keyring,
+		CosignKey:                 cosignKey,
+		CertificateIdentityRegexp: certificateIdentityRegexp,
+		CertificateOidcIssuer:     certificateOidcIssuer,
+		WithFiles:                 withFiles,
+	}
+
+	if err = defaults.Set(option); err != nil {
+		return "", err
+	}
+
+	if err = validate.Struct(option).ValidateErr(); err != nil {
+		return "", err
+	}
+
+	isKeyless := option.CertificateIdentityRegexp != "" && option.CertificateOidcIssuer != ""
+	if option.Keyring == nil && option.CosignKey == nil && !isKeyless {
+		return "", errors.New("You need to provide the keyring, the cosignKey or the certificate identity and issuer")
+	}
+
+	output := new(strings.Builder)
+
+	// Verify the OCI dependencies with cosign
+	if option.CosignKey != nil || isKeyless {
+		chartContents, err := option.Source.File("Chart.yaml").Contents(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "Error when read chart file")
+		}
+		chart := &struct {
+			Dependencies []chartDependency `yaml:"dependencies"`
+		}{}
+		if err = yaml.Unmarshal([]byte(chartContents), chart); err != nil {
+			return "", errors.Wrap(err, "Error when decode YAML file")
+		}
+
+		container := m.cosignContainer()
+
+		cmd := []string{"cosign", "verify"}
+		if option.CosignKey != nil {
+			container = container.WithFile(verifyCosignKeyPath, option.CosignKey)
+			cmd = append(cmd, "--key", verifyCosignKeyPath)
+		} else {
+			cmd = append(cmd, "--certificate-identity-regexp", option.CertificateIdentityRegexp, "--certificate-oidc-issuer", option.CertificateOidcIssuer)
+		}
+
+		for _, dependency := range chart.Dependencies {
+			if !strings.HasPrefix(dependency.Repository, "oci://") {
+				continue
+			}
+			if strings.ContainsAny(dependency.Version, "^~<>=*xX |") {
+				return "", errors.Errorf("The dependency %s need an exact version to be verified with cosign, not %s", dependency.Name, dependency.Version)
+			}
+			reference := fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(strings.TrimPrefix(dependency.Repository, "oci://"), "/"), dependency.Name, dependency.Version)
+
+			// Login with the credentials set with WithRepository, for dependencies on private registries
+			host := registryHost(reference)
+			username, password := m.registryCredentials(host)
+
+			stdout, err := withCosignLogin(container, host, username, password).
+				WithExec(append(cmd, reference)).
+				Stdout(ctx)
+			if err != nil {
+				return "", errors.Wrapf(err, "Error when verify %s with cosign", reference)
+			}
+			fmt.Fprintf(output, "==> Verified %s with cosign\n%s\n", reference, stdout)
+		}
+	}
+
+	// Verify the provenance files when pulling dependencies
+	if option.Keyring != nil {
+		stdout, err := m.withHelmCache(m.BaseHelmContainer).
+			WithDirectory("/project", option.Source).
+			WithWorkdir("/project").
+			WithFiles("/project", option.WithFiles).
+			WithFile(verifyKeyringPath, option.Keyring).
+			WithExec(helper.ForgeScript(`
+set -e
+keyring=%[1]s
+if grep -q "BEGIN PGP PUBLIC KEY BLOCK" ${keyring}; then
+	command -v gpg > /dev/null || apk add --no-cache gnupg
+	gpg --dearmor < ${keyring} > ${keyring}.gpg
+	keyring=${keyring}.gpg
+fi
+helm dependency %[2]s --verify --keyring ${keyring}
+`, verifyKeyringPath, m.dependencyCommand())).
+			Stdout(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "Error when verify dependencies provenance")
+		}
+		fmt.Fprintf(output, "==> Verified dependencies provenance\n%s\n", stdout)
+	}
+
+	return output.String(), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"dagger/test/internal/dagger"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	giteaImage    = "gitea/gitea:1.22.6-rootless"
+	giteaUser     = "dagger"
+	giteaPassword = "dagger-password"
+	giteaApiUrl   = "http://gitea:3000/api/v1"
+)
+
+// TestGitGiteaPullRequest check CreatePullRequest against a real Gitea.
+// The repository have more labels than the Gitea page size, to check the pagination
+func (m *Test) TestGitGiteaPullRequest(ctx context.Context) error {
+	gitea := dag.Container().
+		From(giteaImage).
+		WithEnvVariable("GITEA__security__INSTALL_LOCK", "true").
+		WithEnvVariable("GITEA__server__ROOT_URL", "http://gitea:3000/").
+		WithEnvVariable("GITEA__database__DB_TYPE", "sqlite3").
+		WithExec([]string{"sh", "-c", fmt.Sprintf(
+			"/usr/local/bin/docker-setup.sh && gitea migrate && gitea admin user create --admin --username %[1]s --password %[2]s --email %[1]s@localhost --must-change-password=false",
+			giteaUser, giteaPassword,
+		)}).
+		WithExposedPort(3000).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+
+	client := dag.Container().
+		From("alpine:latest").
+		WithExec([]string{"apk", "add", "--no-cache", "git", "curl"}).
+		WithServiceBinding("gitea", gitea).
+		WithEnvVariable("GITEA_API_URL", giteaApiUrl).
+		WithEnvVariable("GITEA_AUTH", fmt.Sprintf("%s:%s", giteaUser, giteaPassword))
+
+	// Create the repository with 60 labels and the API token
+	tokenPlain, err := client.
+		WithExec([]string{"sh", "-c", `
+set -e
+api() { curl -sSf -u "${GITEA_AUTH}" -H "Content-Type: application/json" -X POST "${GITEA_API_URL}$1" -d "$2"; }
+api /user/repos '{"name":"test","auto_init":true,"default_branch":"main"}' > /dev/null
+for i in $(seq 1 60); do
+	api /repos/dagger/test/labels "{\"name\":\"label-${i}\",\"color\":\"#00aabb\"}" > /dev/null
+done
+api /users/dagger/tokens '{"name":"dagger","scopes":["write:repository","write:issue","write:user"]}' | sed -n 's/.*"sha1":"\([^"]*\)".*/\1/p'
+`}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("Error when init gitea: %w", err)
+	}
+	token := dag.SetSecret("gitea-token", strings.TrimSpace(tokenPlain))
+
+	git := dag.GitLibrary(dagger.GitLibraryOpts{BaseContainer: client}).
+		WithCredential("gitea:3000", token, dagger.GitLibraryWithCredentialOpts{Username: giteaUser}).
+		SetConfig(giteaUser, fmt.Sprintf("%s@localhost", giteaUser))
+	src := git.Clone("http://gitea:3000/dagger/test.git")
+
+	// Open the pull request with a label after the first page
+	pr := git.
+		SetRepo(src.WithNewFile("README.md", "first"), dagger.GitLibrarySetRepoOpts{Branch: "main"}).
+		CreatePullRequest("gitea", "dagger/test", token, "feature", "Test", dagger.GitLibraryCreatePullRequestOpts{
+			Labels: []string{"label-55"},
+			APIURL: giteaApiUrl,
+		})
+	created, err := pr.Created(ctx)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errors.New("The pull request need to be created")
+	}
+	number, err := pr.Number(ctx)
+	if err != nil {
+		return err
+	}
+
+	labels, err := client.
+		WithExec([]string{"sh", "-c", fmt.Sprintf(`curl -sSf -u "${GITEA_AUTH}" "${GITEA_API_URL}/repos/dagger/test/issues/%d/labels"`, number)}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("Error when get pull request labels: %w", err)
+	}
+	if !strings.Contains(labels, `"label-55"`) {
+		return fmt.Errorf("The pull request need to have label-55, got %s", labels)
+	}
+
+	// The existing pull request need to be updated
+	pr = git.
+		SetRepo(src.WithNewFile("README.md", "second"), dagger.GitLibrarySetRepoOpts{Branch: "main"}).
+		CreatePullRequest("gitea", "dagger/test", token, "feature", "Test updated", dagger.GitLibraryCreatePullRequestOpts{
+			APIURL: giteaApiUrl,
+		})
+	if created, err = pr.Created(ctx); err != nil {
+		return err
+	}
+	if created {
+		return errors.New("The existing pull request need to be updated")
+	}
+	updatedNumber, err := pr.Number(ctx)
+	if err != nil {
+		return err
+	}
+	if updatedNumber != number {
+		return fmt.Errorf("The pull request %d need to be updated, not %d", number, updatedNumber)
+	}
+
+	return nil
+}
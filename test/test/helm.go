@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"dagger/test/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+const helmTestChart = `apiVersion: v2
+name: test
+version: 0.1.0
+dependencies:
+  - name: common
+    repository: oci://registry-1.docker.io/bitnamicharts
+    version: 2.20.0
+`
+
+// TestHelmLockedDependencies check that the Chart.lock returned by UpdateDependencies
+// is in sync with the pinned Chart.yaml, so it can be built with locked dependencies
+func (m *Test) TestHelmLockedDependencies(ctx context.Context) error {
+	src := dag.Directory().
+		WithNewFile("Chart.yaml", helmTestChart).
+		WithNewFile("values.yaml", "")
+
+	updated := dag.Helm().UpdateDependencies(src, dagger.HelmUpdateDependenciesOpts{Strategy: "minor"})
+	src = src.WithDirectory(".", updated)
+
+	if _, err := dag.Helm().WithLockedDependencies().Lint(ctx, src); err != nil {
+		return fmt.Errorf("Error when lint chart with locked dependencies: %w", err)
+	}
+
+	versions, err := dag.Container().
+		From("mikefarah/yq:4").
+		WithDirectory("/project", updated).
+		WithWorkdir("/project").
+		WithExec([]string{"sh", "-c", "yq '.dependencies[0].version' Chart.yaml && yq '.dependencies[0].version' Chart.lock"}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("Error when read dependency versions: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(versions), "\n")
+	if len(lines) != 2 || lines[0] != lines[1] {
+		return fmt.Errorf("The Chart.yaml and Chart.lock versions need to be the same, got %s", versions)
+	}
+	if !strings.HasPrefix(lines[0], "2.") {
+		return fmt.Errorf("The dependency need to be bumped within the minor strategy, got %s", lines[0])
+	}
+
+	return nil
+}